@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+const usage = `usage: chirpy [--debug] [command]
+
+With no command chirpy starts the server. Commands:
+
+  migrate status    list schema migrations and whether they are applied
+  migrate up        apply all pending migrations
+  migrate down      revert the most recently applied migration
+  repair            report ids that were handed out twice in database.json
+  backup [file]     write a gzip'd, checksummed snapshot of the database
+  restore <file>    verify a backup and replace the database with it; stop
+                    the server first
+  db rotate-key     rewrap the database's data key from DB_ENCRYPTION_KEY to
+                    DB_NEW_ENCRYPTION_KEY (or DB_NEW_ENCRYPTION_KEY_FILE);
+                    also available as rotate-key`
+
+// runCommand runs a chirpy subcommand such as `chirpy migrate up`
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "repair":
+		return repairCommand(args[1:])
+	case "backup":
+		return backupCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "db":
+		return dbCommand(args[1:])
+	case "rotate-key":
+		return rotateKeyCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], usage)
+	}
+}
+
+// dbCommand runs a `chirpy db` subcommand
+func dbCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "rotate-key":
+		return rotateKeyCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown db command %q\n\n%s", args[0], usage)
+	}
+}
+
+func migrateCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+
+	driver, path, opts := databaseConfig()
+	migrator, closer, err := database.OpenMigrator(driver, path, opts)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	switch args[0] {
+	case "status":
+	case "up":
+		if err := migrator.MigrateUp(); err != nil {
+			return err
+		}
+	case "down":
+		if err := migrator.MigrateDown(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q\n\n%s", args[0], usage)
+	}
+
+	status, err := migrator.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, m := range status {
+		state := "pending"
+		if m.Applied {
+			state = "applied"
+		}
+		fmt.Printf("%4d  %-8s %s\n", m.Version, state, m.Name)
+	}
+	return nil
+}
+
+func repairCommand(args []string) error {
+	if len(args) != 0 {
+		return errors.New(usage)
+	}
+
+	driver, path, opts := databaseConfig()
+	if driver == "sqlite" {
+		fmt.Println("SQLite ids come from AUTOINCREMENT and are never reused, nothing to check")
+		return nil
+	}
+
+	collisions, err := database.CheckIDs(path, opts)
+	if err != nil {
+		return err
+	}
+	if len(collisions) == 0 {
+		fmt.Printf("no id collisions found in %s\n", path)
+		return nil
+	}
+
+	for _, c := range collisions {
+		fmt.Println(c)
+	}
+	return fmt.Errorf("found %d id collisions in %s", len(collisions), path)
+}
+
+func backupCommand(args []string) error {
+	if len(args) > 1 {
+		return errors.New(usage)
+	}
+	file := fmt.Sprintf("chirpy-backup-%s.gz", time.Now().UTC().Format("20060102-150405"))
+	if len(args) == 1 {
+		file = args[0]
+	}
+
+	// A read-only store doesn't touch the database files, so this is safe
+	// while the server is running
+	driver, path, opts := databaseConfig()
+	db, err := database.OpenReadOnly(driver, path, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	out, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := db.Backup(out); err != nil {
+		out.Close()
+		os.Remove(file)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("backed up %s to %s\n", path, file)
+	return nil
+}
+
+func restoreCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+
+	in, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	driver, path, _ := databaseConfig()
+	if err := database.Restore(driver, path, in); err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %s from %s\n", path, args[0])
+	return nil
+}
+
+func rotateKeyCommand(args []string) error {
+	if len(args) != 0 {
+		return errors.New(usage)
+	}
+
+	driver, path, opts := databaseConfig()
+	if driver == "sqlite" {
+		return errors.New("encryption at rest is only supported by the json driver")
+	}
+	if opts.MasterKey == nil {
+		return errors.New("set DB_ENCRYPTION_KEY or DB_ENCRYPTION_KEY_FILE to the current master key")
+	}
+	newKey := masterKey("DB_NEW_ENCRYPTION_KEY")
+	if newKey == nil {
+		return errors.New("set DB_NEW_ENCRYPTION_KEY or DB_NEW_ENCRYPTION_KEY_FILE to the new master key")
+	}
+
+	if err := database.RotateKey(path, opts.MasterKey, newKey); err != nil {
+		return err
+	}
+
+	fmt.Printf("rotated the master key of %s; set DB_ENCRYPTION_KEY to the new key before the next restart\n", path)
+	return nil
+}
@@ -1,7 +1,40 @@
 package config
 
+import (
+	"time"
+
+	"github.com/lordmoma/chirpy/internal/mail"
+	"github.com/lordmoma/chirpy/internal/validation"
+)
+
 type ApiConfig struct {
 	FileserverHits uint64
 	JwtSecret      string
 	APIKey string
+	// AdminKey authorises admin endpoints that expose data, such as /admin/backup
+	AdminKey string
+	// EditWindow is how long after posting a chirp can be edited, 0 for no limit
+	EditWindow time.Duration
+	// EditRequiresRed limits editing chirps to Chirpy Red members
+	EditRequiresRed bool
+	// Validation checks and cleans up the body of every new or edited chirp
+	Validation *validation.Pipeline
+	// Mailer sends the emails asking users to verify their address or reset
+	// their password
+	Mailer mail.Mailer
+	// VerifyEmailURL, if set, is the page verification emails link to, with
+	// the token in its token query parameter
+	VerifyEmailURL string
+	// VerificationResendInterval is how long a user must wait before asking
+	// for another verification email
+	VerificationResendInterval time.Duration
+	// RequireVerifiedEmail stops users who haven't verified their email from
+	// posting chirps
+	RequireVerifiedEmail bool
+	// ResetPasswordURL, if set, is the page password reset emails link to,
+	// with the token in its token query parameter
+	ResetPasswordURL string
+	// PasswordResetInterval is how long after a password reset email is sent
+	// before another can be sent to the same user
+	PasswordResetInterval time.Duration
 }
\ No newline at end of file
@@ -0,0 +1,224 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A backup is the store's native file (the JSON snapshot or the SQLite
+// database) compressed with gzip. The gzip header names the file, so a backup
+// can't be restored into the wrong kind of store, and carries the SHA-256 of
+// the uncompressed contents, so a damaged backup is rejected on restore. The
+// snapshot of an encrypted database stays encrypted in the backup, and its
+// keyring travels in the header's extra field.
+
+const checksumPrefix = "sha256:"
+
+// Backup names stored in the gzip header
+const (
+	jsonBackupName   = "database.json"
+	sqliteBackupName = "database.db"
+)
+
+// ErrChecksumMismatch is returned by Restore when the backup doesn't match its checksum
+var ErrChecksumMismatch = errors.New("backup checksum does not match its contents")
+
+// Backup writes a consistent, gzip-compressed snapshot of the database to w.
+// Writers are only blocked while the dataset is copied.
+func (db *DB) Backup(w io.Writer) error {
+	db.mux.RLock()
+	snapshot := db.data.clone()
+	snapshot.LogSequence = db.seq
+	db.mux.RUnlock()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	data, err = db.env.encodeFile(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+
+	var ring []byte
+	if db.env != nil {
+		if ring, err = os.ReadFile(keyringPath(db.path)); err != nil {
+			return err
+		}
+	}
+	return writeBackup(w, jsonBackupName, ring, bytes.NewReader(data))
+}
+
+// Backup writes a consistent, gzip-compressed copy of the database to w.
+// VACUUM INTO copies the database inside a read transaction, so writers
+// carry on while the backup is compressed and streamed.
+func (s *SQLiteDB) Backup(w io.Writer) error {
+	dir, err := os.MkdirTemp("", "chirpy-backup")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	copyPath := filepath.Join(dir, sqliteBackupName)
+	if _, err := s.db.Exec(`VACUUM INTO ?`, copyPath); err != nil {
+		return err
+	}
+
+	file, err := os.Open(copyPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return writeBackup(w, sqliteBackupName, nil, file)
+}
+
+// OpenReadOnly opens the store named by driver at path for reading only. It
+// doesn't migrate, compact or otherwise modify the database, so it is safe to
+// use while a server has the same database open.
+func OpenReadOnly(driver, path string, opts Options) (Store, error) {
+	switch driver {
+	case "", "json":
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		db, err := openDB(path, opts, true)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.load(); err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "sqlite":
+		if opts.MasterKey != nil {
+			return nil, errEncryptionUnsupported
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		return openSQLiteDB("file:" + path + "?mode=ro")
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
+
+// writeBackup hashes payload, then writes it to w gzip-compressed with the
+// name, checksum and keyring (if any) in the header
+func writeBackup(w io.Writer, name string, ring []byte, payload io.ReadSeeker) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, payload); err != nil {
+		return err
+	}
+	if _, err := payload.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	zw.Name = name
+	zw.Comment = checksumPrefix + hex.EncodeToString(hash.Sum(nil))
+	zw.Extra = ring
+	if _, err := io.Copy(zw, payload); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Restore replaces the database named by driver at path with the backup read
+// from r. The backup is verified before anything is replaced. The server must
+// not be running.
+func Restore(driver, path string, r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	want := jsonBackupName
+	if driver == "sqlite" {
+		want = sqliteBackupName
+	}
+	if zr.Name != want {
+		return fmt.Errorf("backup holds %q, expected %q for the %q driver", zr.Name, want, driver)
+	}
+	if !strings.HasPrefix(zr.Comment, checksumPrefix) {
+		return errors.New("backup has no checksum")
+	}
+	checksum := strings.TrimPrefix(zr.Comment, checksumPrefix)
+
+	var ring keyring
+	if len(zr.Extra) > 0 {
+		if err := json.Unmarshal(zr.Extra, &ring); err != nil {
+			return fmt.Errorf("reading backup keyring: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), zr); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return ErrChecksumMismatch
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Stage the backup's keyring next to its data too, so nothing of the old
+	// database is touched until the backup is ready to take its place
+	stagedRing := keyringPath(tmp.Name())
+	if len(zr.Extra) > 0 {
+		defer os.Remove(stagedRing)
+		if err := ring.write(tmp.Name()); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// The backup is complete in itself, so replace or drop anything the old
+	// database kept next to its main file
+	var sidecars []string
+	if driver == "sqlite" {
+		sidecars = []string{path + "-wal", path + "-shm", path + "-journal"}
+	} else {
+		sidecars = []string{path + ".wal", path + ".wal.old"}
+		if len(zr.Extra) > 0 {
+			if err := os.Rename(stagedRing, keyringPath(path)); err != nil {
+				return err
+			}
+		} else {
+			sidecars = append(sidecars, keyringPath(path))
+		}
+	}
+	for _, sidecar := range sidecars {
+		if err := os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return syncDir(filepath.Dir(path))
+}
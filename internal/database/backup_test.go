@@ -0,0 +1,100 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// TestRestoreCorrupted checks a backup that doesn't match its checksum, or
+// isn't a valid archive, is rejected without touching the database
+func TestRestoreCorrupted(t *testing.T) {
+	db := newTestDB(t)
+	user, err := db.CreateUser("a@example.com", "pw", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp(NewChirp{AuthorID: user.ID, Body: "backed up"}); err != nil {
+		t.Fatal(err)
+	}
+	var backup bytes.Buffer
+	if err := db.Backup(&backup); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recompress the snapshot changed, keeping the header and its checksum
+	zr, err := gzip.NewReader(bytes.NewReader(backup.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tampered bytes.Buffer
+	zw := gzip.NewWriter(&tampered)
+	zw.Header = zr.Header
+	if _, err := zw.Write(bytes.Replace(data, []byte("backed up"), []byte("tampered!"), 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := backup.Bytes()[:backup.Len()/2]
+
+	path := filepath.Join(t.TempDir(), "database.json")
+	restored, err := NewDB(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.CreateUser("b@example.com", "pw", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore("json", path, &tampered); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("restoring a tampered backup returned %v, want %v", err, ErrChecksumMismatch)
+	}
+	if err := Restore("json", path, bytes.NewReader(truncated)); err == nil {
+		t.Fatal("restored a truncated backup")
+	}
+
+	restored, err = NewDB(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.GetUserbyEmail("b@example.com"); err != nil {
+		t.Fatalf("database changed by a failed restore: %v", err)
+	}
+	if chirps, err := restored.GetChirps(Page{}); err != nil {
+		t.Fatal(err)
+	} else if len(chirps) != 0 {
+		t.Fatalf("got %d chirps after a failed restore, want 0", len(chirps))
+	}
+
+	// The untouched backup still restores
+	if err := restored.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore("json", path, &backup); err != nil {
+		t.Fatal(err)
+	}
+	restored, err = NewDB(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	chirps, err := restored.GetChirps(Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 1 || chirps[0].Body != "backed up" {
+		t.Fatalf("got %+v after restoring the backup", chirps)
+	}
+}
@@ -2,78 +2,190 @@ package database
 
 import (
 	"errors"
-	"sort"
+	"time"
 )
 
+// ErrChirpNotFound is returned when no chirp has the requested id
+var ErrChirpNotFound = errors.New("chirp not found")
+
+// ErrParentNotFound is returned when a reply's parent chirp doesn't exist
+var ErrParentNotFound = errors.New("chirp being replied to not found")
+
 type Chirp struct {
 	ID   int    `json:"id"`
 	AuthorID int    `json:"author_id"`
 	Body string `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	Tags []string `json:"tags,omitempty"`
+	Mentions []Mention `json:"mentions,omitempty"`
+	InReplyTo int `json:"in_reply_to,omitempty"`
+	QuotedChirpID int `json:"quoted_chirp_id,omitempty"`
+	// Media are the images attached to the chirp
+	Media []Attachment `json:"media,omitempty"`
+	// Quoted embeds the chirp QuotedChirpID names when the chirp is read; it
+	// is never stored
+	Quoted *QuotedChirp `json:"quoted_chirp,omitempty"`
+	// EditedAt is when the chirp was last edited, nil if it never was
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// Deleted hides the chirp. A chirp in the trash keeps everything and has
+	// DeletedAt set; once purged, a chirp with replies leaves a tombstone that
+	// keeps its id and place in the thread but nothing else.
+	Deleted bool `json:"deleted,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// LikeCount and RechirpCount are kept in step with the chirp's reactions
+	LikeCount    int `json:"like_count"`
+	RechirpCount int `json:"rechirp_count"`
 }
 
-// CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateChirp(authorID int, body string) (Chirp, error) {
+// NewChirp is what a user provides to create a chirp
+type NewChirp struct {
+	AuthorID int
+	Body     string
+	// InReplyTo is the id of the chirp this one replies to, 0 for none
+	InReplyTo int
+	// QuotedChirpID is the id of the chirp this one quotes, 0 for none
+	QuotedChirpID int
+	// MediaIDs are the ids of up to MaxChirpMedia images the author uploaded
+	MediaIDs []int
+}
 
-	dbStructure, err := db.loadDB()
+// tombstone returns what is left of the chirp once it is deleted
+func (chirp Chirp) tombstone() Chirp {
+	return Chirp{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		InReplyTo: chirp.InReplyTo,
+		Deleted:   true,
+	}
+}
+
+// CreateChirp creates a new chirp and saves it to disk
+func (db *DB) CreateChirp(c NewChirp) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var err error
+		chirp, err = tx.createChirp(c)
+		return err
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	id := len(dbStructure.Chirps) + 1
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.view(chirp), nil
+}
 
-	chirp := Chirp{
-		ID:   id,
-		AuthorID: authorID,
-		Body: body,
+// checkReferences returns an error unless the chirps c replies to and
+// quotes, if any, exist, aren't deleted and aren't by a user who has blocked
+// or been blocked by its author, and its author uploaded its media. It
+// returns the media as attachments.
+func (tx *Tx) checkReferences(c NewChirp) ([]Attachment, error) {
+	if c.InReplyTo != 0 {
+		parent, ok := tx.Chirp(c.InReplyTo)
+		if !ok || parent.Deleted {
+			return nil, ErrParentNotFound
+		}
+		if tx.db.index.restrictions.blocked(c.AuthorID, parent.AuthorID) {
+			return nil, ErrBlocked
+		}
 	}
-
-	dbStructure.Chirps[id] = chirp
-	if err := db.writeDB(dbStructure); err != nil {
-		return Chirp{}, err
+	if c.QuotedChirpID != 0 {
+		quoted, ok := tx.Chirp(c.QuotedChirpID)
+		if !ok || quoted.Deleted {
+			return nil, ErrQuotedNotFound
+		}
+		if tx.db.index.restrictions.blocked(c.AuthorID, quoted.AuthorID) {
+			return nil, ErrBlocked
+		}
 	}
-	return chirp, nil
+	return tx.attachments(c.AuthorID, c.MediaIDs)
 }
 
-// GetChirps returns all chirps in the database
-func (db *DB) GetChirps() ([]Chirp, error) {
+// mentions resolves the mentions in a chirp authorID is writing, leaving out
+// users who blocked the author or whom the author blocked
+func (tx *Tx) mentions(authorID int, body string) ([]Mention, error) {
+	mentions := resolveMentions(body, func(handle string) (int, bool) {
+		user, ok := tx.UserByHandle(handle)
+		return user.ID, ok
+	})
+	return dropBlockedMentions(mentions, func(userID int) (bool, error) {
+		return tx.db.index.restrictions.blocked(authorID, userID), nil
+	})
+}
 
-	dbStructure, err := db.loadDB()
+// createChirp creates a new chirp in the transaction
+func (tx *Tx) createChirp(c NewChirp) (Chirp, error) {
+	media, err := tx.checkReferences(c)
 	if err != nil {
-		return nil, err
+		return Chirp{}, err
 	}
-
-	chirps := make([]Chirp, 0, len(dbStructure.Chirps))
-	for _, chirp := range dbStructure.Chirps {
-		chirps = append(chirps, chirp)
+	mentions, err := tx.mentions(c.AuthorID, c.Body)
+	if err != nil {
+		return Chirp{}, err
 	}
 
-	sort.Slice(chirps, func(i, j int) bool {
-		return chirps[i].AuthorID < chirps[j].AuthorID && chirps[i].ID < chirps[j].ID
-	})
-
-	return chirps, nil
+	chirp := Chirp{
+		ID:        tx.nextID(seqChirps),
+		AuthorID:  c.AuthorID,
+		Body:      c.Body,
+		CreatedAt: time.Now().UTC(),
+		Tags:      extractTags(c.Body),
+		Mentions:      mentions,
+		InReplyTo:     c.InReplyTo,
+		QuotedChirpID: c.QuotedChirpID,
+		Media:         media,
+	}
+	if err := tx.log(logEntry{Op: opChirpCreated, Chirp: &chirp}); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(authorID, id int) error {
+// GetChirps returns a page of the chirps in the database, ordered by id
+func (db *DB) GetChirps(page Page) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
+	return db.chirpPage(page.window(db.index.chirpIDs)), nil
+}
 
-	if _, ok := dbStructure.Chirps[id]; !ok {
-		return errors.New("chirp not found")
-	}
+// GetChirp returns the chirp with the given id
+func (db *DB) GetChirp(id int) (Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
-	if dbStructure.Chirps[id].AuthorID != authorID {
-		return errors.New("unauthorised to delete a chirp")
+	chirp, ok := db.data.Chirps[id]
+	if !ok {
+		return Chirp{}, ErrChirpNotFound
 	}
+	return db.view(chirp), nil
+}
 
-	delete(dbStructure.Chirps, id)
+// GetChirpsByAuthor returns a page of the author's chirps, ordered by id
+func (db *DB) GetChirpsByAuthor(authorID int, page Page) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
-	if err := db.writeDB(dbStructure); err != nil {
-		return err
-	}
+	return db.chirpPage(page.window(db.index.chirpsByAuthor[authorID])), nil
+}
 
-	return nil
-}
\ No newline at end of file
+// DeleteChirp moves a chirp owned by authorID to the trash, where it is
+// hidden but can be restored until it is purged (see trash.go)
+func (db *DB) DeleteChirp(authorID, id int) error {
+	return db.Update(func(tx *Tx) error {
+		chirp, ok := tx.Chirp(id)
+		if !ok || chirp.Deleted {
+			return ErrChirpNotFound
+		}
+
+		if chirp.AuthorID != authorID {
+			return ErrNotChirpAuthor
+		}
+
+		now := time.Now().UTC()
+		chirp.Deleted = true
+		chirp.DeletedAt = &now
+		return tx.log(logEntry{Op: opChirpUpdated, Chirp: &chirp})
+	})
+}
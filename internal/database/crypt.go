@@ -0,0 +1,321 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Encryption at rest uses envelope keys. A random data key encrypts the
+// snapshot and every log entry with AES-256-GCM; the data key itself is
+// stored next to the database in a keyring file, encrypted ("wrapped") with
+// the master key from the environment. Rotating the master key only rewraps
+// the data key, so nothing else is rewritten and a running server keeps going
+// with the data key it already holds.
+
+// encryptedFileMagic starts every encrypted snapshot, so plaintext databases
+// written before encryption was enabled are still readable
+var encryptedFileMagic = []byte("CHIRPYENC1\n")
+
+// encryptedLinePrefix starts every encrypted log line
+const encryptedLinePrefix = "enc:"
+
+// Additional data bound into each ciphertext so a sealed snapshot can't be
+// passed off as a log entry and vice versa
+var (
+	aadSnapshot = []byte("chirpy snapshot")
+	aadLog      = []byte("chirpy log entry")
+	aadDataKey  = []byte("chirpy data key")
+)
+
+// ErrEncrypted is returned when a database is encrypted but no master key is configured
+var ErrEncrypted = errors.New("database is encrypted, set DB_ENCRYPTION_KEY or DB_ENCRYPTION_KEY_FILE")
+
+// ErrPlaintext is returned when an encrypted database holds plaintext data,
+// which could have been written by anyone
+var ErrPlaintext = errors.New("encrypted database holds unencrypted data")
+
+// keyring is the file holding the wrapped data key
+type keyring struct {
+	// MasterKeyID identifies the master key that wrapped the data key, so a
+	// wrong key is reported as such rather than as corrupt data
+	MasterKeyID string `json:"master_key_id"`
+	DataKey     string `json:"data_key"`
+	// Converting is set while a plaintext database is being encrypted, the
+	// only time plaintext data is accepted
+	Converting bool `json:"converting,omitempty"`
+}
+
+// envelope seals and opens data with the unwrapped data key
+type envelope struct {
+	aead cipher.AEAD
+	// ring is the keyring the data key came from
+	ring keyring
+}
+
+func keyringPath(path string) string {
+	return path + ".keys"
+}
+
+// ParseMasterKey decodes a base64 master key, which must be 32 bytes
+func ParseMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key must be base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func masterKeyID(masterKey []byte) string {
+	sum := sha256.Sum256(masterKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// openEnvelope loads the data key for the database at path. With a master
+// key it unwraps the existing data key, or, if create is set, creates one for
+// a database that has none yet. Otherwise it returns nil for a plaintext
+// database, or ErrEncrypted if the database has a keyring but no key is set.
+func openEnvelope(path string, masterKey []byte, create bool) (*envelope, error) {
+	data, err := os.ReadFile(keyringPath(path))
+	if os.IsNotExist(err) {
+		if masterKey == nil || !create {
+			return nil, nil
+		}
+		return createKeyring(path, masterKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if masterKey == nil {
+		return nil, ErrEncrypted
+	}
+
+	var ring keyring
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return nil, fmt.Errorf("reading keyring: %w", err)
+	}
+	dataKey, err := ring.unwrap(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &envelope{aead: aead, ring: ring}, nil
+}
+
+// createKeyring generates a data key, wraps it with masterKey and writes the
+// keyring. The database may still hold plaintext, so the keyring starts out
+// converting until finishConverting is called.
+func createKeyring(path string, masterKey []byte) (*envelope, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	ring, err := wrapDataKey(dataKey, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	ring.Converting = true
+	if err := ring.write(path); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &envelope{aead: aead, ring: ring}, nil
+}
+
+// converting reports whether the database is being encrypted, so may still
+// hold plaintext
+func (env *envelope) converting() bool {
+	return env != nil && env.ring.Converting
+}
+
+// finishConverting records that the database at path is fully encrypted, so
+// plaintext is rejected from now on. Everything must have been rewritten
+// encrypted first.
+func (env *envelope) finishConverting(path string) error {
+	ring := env.ring
+	ring.Converting = false
+	if err := ring.write(path); err != nil {
+		return err
+	}
+	env.ring = ring
+	return nil
+}
+
+func wrapDataKey(dataKey, masterKey []byte) (keyring, error) {
+	kek, err := newAEAD(masterKey)
+	if err != nil {
+		return keyring{}, err
+	}
+	wrapped, err := seal(kek, dataKey, aadDataKey)
+	if err != nil {
+		return keyring{}, err
+	}
+	return keyring{
+		MasterKeyID: masterKeyID(masterKey),
+		DataKey:     base64.StdEncoding.EncodeToString(wrapped),
+	}, nil
+}
+
+func (ring keyring) unwrap(masterKey []byte) ([]byte, error) {
+	if id := masterKeyID(masterKey); id != ring.MasterKeyID {
+		return nil, fmt.Errorf("master key %s does not match the keyring, which was wrapped with %s", id, ring.MasterKeyID)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(ring.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return unseal(kek, wrapped, aadDataKey)
+}
+
+func (ring keyring) write(path string) error {
+	data, err := json.MarshalIndent(ring, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(keyringPath(path), append(data, '\n'))
+}
+
+// RotateKey rewraps the data key of the database at path from oldKey to
+// newKey. Only the keyring changes, so it is safe while the server runs;
+// restart it with the new key whenever convenient.
+func RotateKey(path string, oldKey, newKey []byte) error {
+	data, err := os.ReadFile(keyringPath(path))
+	if os.IsNotExist(err) {
+		return errors.New("database is not encrypted")
+	}
+	if err != nil {
+		return err
+	}
+
+	var ring keyring
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return fmt.Errorf("reading keyring: %w", err)
+	}
+	dataKey, err := ring.unwrap(oldKey)
+	if err != nil {
+		return err
+	}
+
+	rotated, err := wrapDataKey(dataKey, newKey)
+	if err != nil {
+		return err
+	}
+	rotated.Converting = ring.Converting
+	return rotated.write(path)
+}
+
+// seal encrypts plaintext, returning the random nonce followed by the ciphertext
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func unseal(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, errors.New("decryption failed, wrong key or corrupt data")
+	}
+	return plaintext, nil
+}
+
+// encodeFile encrypts a snapshot when the database is encrypted
+func (env *envelope) encodeFile(data []byte) ([]byte, error) {
+	if env == nil {
+		return data, nil
+	}
+	sealed, err := seal(env.aead, data, aadSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, encryptedFileMagic...), sealed...), nil
+}
+
+// decodeFile decrypts a snapshot, passing plaintext snapshots through
+// unless the database is encrypted
+func (env *envelope) decodeFile(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedFileMagic) {
+		return env.plaintext(data)
+	}
+	if env == nil {
+		return nil, ErrEncrypted
+	}
+	return unseal(env.aead, data[len(encryptedFileMagic):], aadSnapshot)
+}
+
+// encodeLine encrypts a log line when the database is encrypted
+func (env *envelope) encodeLine(line []byte) ([]byte, error) {
+	if env == nil {
+		return line, nil
+	}
+	sealed, err := seal(env.aead, line, aadLog)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(encryptedLinePrefix + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// decodeLine decrypts a log line, passing plaintext lines through unless
+// the database is encrypted
+func (env *envelope) decodeLine(line []byte) ([]byte, error) {
+	if !bytes.HasPrefix(line, []byte(encryptedLinePrefix)) {
+		return env.plaintext(line)
+	}
+	if env == nil {
+		return nil, ErrEncrypted
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(line[len(encryptedLinePrefix):]))
+	if err != nil {
+		return nil, err
+	}
+	return unseal(env.aead, sealed, aadLog)
+}
+
+// plaintext passes unencrypted data through for a plaintext database, or one
+// still being converted. Anything else would let unauthenticated data into
+// an encrypted database.
+func (env *envelope) plaintext(data []byte) ([]byte, error) {
+	if env != nil && !env.converting() {
+		return nil, ErrPlaintext
+	}
+	return data, nil
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWrongMasterKey checks an encrypted database can only be opened with its
+// master key, and that failing to open it leaves it readable
+func TestWrongMasterKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	key := bytes.Repeat([]byte{1}, 32)
+	db, err := NewDB(path, Options{MasterKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("a@example.com", "pw", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp(NewChirp{AuthorID: user.ID, Body: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, path + ".wal"} {
+		if data, err := os.ReadFile(p); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		} else if bytes.Contains(data, []byte("secret")) {
+			t.Fatalf("%s holds the chirp in plaintext", p)
+		}
+	}
+
+	if _, err := NewDB(path, Options{MasterKey: bytes.Repeat([]byte{2}, 32)}); err == nil {
+		t.Fatal("opened with the wrong master key")
+	}
+	if _, err := NewDB(path, Options{}); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("opening without a key returned %v, want %v", err, ErrEncrypted)
+	}
+
+	db, err = NewDB(path, Options{MasterKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	chirps, err := db.GetChirps(Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 1 || chirps[0].Body != "secret" {
+		t.Fatalf("got %+v after reopening with the right key", chirps)
+	}
+}
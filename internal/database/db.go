@@ -3,14 +3,36 @@ package database
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"sync"
 )
 
-// DB represents a database connection
+// DB represents a database connection. The dataset is held in memory; the
+// file at path is a periodic snapshot and every change since that snapshot
+// is appended to the operation log next to it (see wal.go).
 type DB struct {
-	path string
-	mux  *sync.RWMutex
-	// dbStructure DBStructure
+	path  string
+	mux   *sync.RWMutex
+	data  DBStructure
+	index dbIndex
+
+	// snowflake generates ids when configured, otherwise ids come from data.Sequences
+	snowflake *snowflake
+	// env encrypts the snapshot and log when encryption at rest is enabled, nil otherwise
+	env *envelope
+
+	// readOnly databases replay the log without modifying any file, so they
+	// can be opened while a server has the database open
+	readOnly bool
+
+	wal    *os.File
+	seq    uint64 // sequence number of the last logged operation
+	logged int    // operations logged since the last snapshot
+
+	// compactMux serialises snapshots so only one runs at a time
+	compactMux sync.Mutex
+	done       chan struct{}
+	wg         sync.WaitGroup
 }
 
 // DBStructure represents the structure of the database file
@@ -18,96 +40,194 @@ type DBStructure struct {
 	Chirps map[int]Chirp `json:"chirps"`
 	Users  map[int]User  `json:"users"`
 	Tokens  map[int]RevokedToken  `json:"revoked_tokens"`
+	Likes    map[int]Reaction `json:"likes"`
+	Rechirps map[int]Reaction `json:"rechirps"`
+	Revisions map[int]Revision `json:"revisions"`
+	Drafts    map[int]Draft    `json:"drafts"`
+	Media     map[int]Media    `json:"media"`
+	Follows   map[int]Follow   `json:"follows"`
+	Restrictions map[int]Restriction `json:"restrictions"`
+	// Sequences holds the last id handed out for each entity
+	Sequences map[string]int `json:"sequences"`
+
+	SchemaVersion int `json:"schema_version"`
+	// LogSequence is the sequence number of the last logged operation
+	// included in the snapshot
+	LogSequence uint64 `json:"log_sequence"`
 }
 
-// NewDB creates a new database connection and creates the database file if it doesn't exist
-func NewDB(path string) (*DB, error) {
+// NewDB creates a new database connection, creates the database file if it
+// doesn't exist, upgrades it to the latest schema version and replays the
+// operation log on top of it
+func NewDB(path string, opts Options) (*DB, error) {
+	db, err := openDB(path, opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.MigrateUp(); err != nil {
+		return nil, err
+	}
+
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+
+	// A key set on a plaintext database encrypts all of it straight away,
+	// rather than as it is next compacted
+	if db.env.converting() {
+		if err := db.snapshot(true); err != nil {
+			return nil, err
+		}
+		if err := db.env.finishConverting(db.path); err != nil {
+			return nil, err
+		}
+	}
+
+	db.done = make(chan struct{})
+	db.wg.Add(1)
+	go db.compactLoop()
+
+	return db, nil
+}
+
+// openDB opens the database file without running any migrations
+func openDB(path string, opts Options, readOnly bool) (*DB, error) {
 	db := &DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+		path:     path,
+		mux:      &sync.RWMutex{},
+		readOnly: readOnly,
 	}
 
 	// Open the file with read and write permissions
-	file, err := os.OpenFile(db.path, os.O_RDWR|os.O_CREATE, 0666)
+	file, err := os.OpenFile(db.path, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
 	// Assign the file to the DB
 	db.path = file.Name()
 
-	if err := db.ensureDB(); err != nil {
+	db.snowflake, err = newIDGenerator(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	db.env, err = openEnvelope(db.path, opts.MasterKey, !readOnly)
+	if err != nil {
 		return nil, err
 	}
-	// // Decode the JSON file into DBStructure
-	// err = json.NewDecoder(file).Decode(&db.dbStructure)
-	// if err != nil {
-	// 	return nil, err
-	// }
 
 	return db, nil
 }
 
-// ensureDB creates a new database file if it doesn't exist
-func (db *DB) ensureDB() error {
-	// Check if the database file exists
-	_, err := os.Stat(db.path)
-	if os.IsNotExist(err) {
-		// Create an empty database file
+// Close stops the background compaction, writes a final snapshot and closes the operation log
+func (db *DB) Close() error {
+	if db.done == nil {
+		return nil
+	}
+	close(db.done)
+	db.wg.Wait()
+	db.done = nil
 
-		dbStructure := DBStructure{
-			Chirps: make(map[int]Chirp),
-			Users:  make(map[int]User),
-		}
+	err := db.compact()
 
-		if err := db.writeDB(dbStructure); err != nil {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if cerr := db.wal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// load reads the snapshot into memory and replays the operation log on top of it
+func (db *DB) load() error {
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return err
+	}
+	data, err = db.env.decodeFile(data)
+	if err != nil {
+		return err
+	}
+	var dbStructure DBStructure
+	if err := json.Unmarshal(data, &dbStructure); err != nil {
+		return err
+	}
+	db.data = dbStructure
+	db.index.rebuild(db.data)
+	db.seq = dbStructure.LogSequence
+
+	// An old log is only left behind if we crashed during a snapshot, replay
+	// it first and snapshot straight away so it can be removed
+	_, err = os.Stat(db.oldWALPath())
+	leftover := err == nil
+	if leftover {
+		if err := db.replay(db.oldWALPath()); err != nil {
 			return err
 		}
 	}
-	return nil
-}
 
-// loadDB reads the database file into memory
-func (db *DB) loadDB() (DBStructure, error) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
+	if err := db.replay(db.walPath()); err != nil {
+		return err
+	}
 
-	var dbStructure DBStructure
+	// The sequences hold the highest id of each entity, snowflakes included
+	if db.snowflake != nil {
+		for _, id := range db.data.Sequences {
+			db.snowflake.resume(id)
+		}
+	}
 
-	file, err := os.Open(db.path)
-	if err != nil {
-		return dbStructure, err
+	if db.readOnly {
+		return nil
 	}
-	defer file.Close()
 
-	err = json.NewDecoder(file).Decode(&dbStructure)
+	db.wal, err = os.OpenFile(db.walPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
 	if err != nil {
-		return dbStructure, err
+		return err
 	}
-	return dbStructure, nil
-}
 
-// writeDB writes the database file to disk
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
+	if leftover {
+		return db.compact()
+	}
+	return nil
+}
 
-	file, err := os.Create(db.path)
+// writeFileAtomic writes data to a temporary file, syncs it and renames it
+// over path, so readers see either the old or the new contents. The file is
+// only readable by its owner, like the log.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer os.Remove(tmp.Name())
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(&dbStructure); err != nil {
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
 		return err
 	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
 
-	// err = json.NewEncoder(file).Encode(&dbStructure)
-	// if err != nil {
-	// 	return err
-	// }
-
-	return nil
+// syncDir flushes directory entries (renames, new files) to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrDraftNotFound is returned when the author has no draft with the requested id
+var ErrDraftNotFound = errors.New("draft not found")
+
+// Draft is a chirp saved to be published later, by its author or, if it has
+// a PublishAt time, by the scheduler
+type Draft struct {
+	ID            int       `json:"id"`
+	AuthorID      int       `json:"author_id"`
+	Body          string    `json:"body"`
+	InReplyTo     int       `json:"in_reply_to,omitempty"`
+	QuotedChirpID int       `json:"quoted_chirp_id,omitempty"`
+	MediaIDs      []int     `json:"media_ids,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	// PublishAt schedules the draft to be published as a chirp at that time
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	// PublishError says why a scheduled draft could not be published; the
+	// draft is then left unscheduled
+	PublishError string `json:"publish_error,omitempty"`
+}
+
+// chirp returns what the draft publishes
+func (d Draft) chirp() NewChirp {
+	return NewChirp{AuthorID: d.AuthorID, Body: d.Body, InReplyTo: d.InReplyTo, QuotedChirpID: d.QuotedChirpID, MediaIDs: d.MediaIDs}
+}
+
+// publishFailed reports whether err means the draft can never be published
+// as it is, rather than that publishing should be retried
+func publishFailed(err error) bool {
+	return errors.Is(err, ErrParentNotFound) || errors.Is(err, ErrQuotedNotFound) || errors.Is(err, ErrBlocked)
+}
+
+// CreateDraft saves c as a draft, scheduled to be published at publishAt
+// unless it is nil
+func (db *DB) CreateDraft(c NewChirp, publishAt *time.Time) (Draft, error) {
+	var draft Draft
+	err := db.Update(func(tx *Tx) error {
+		if _, err := tx.checkReferences(c); err != nil {
+			return err
+		}
+
+		draft = Draft{
+			ID:            tx.nextID(seqDrafts),
+			AuthorID:      c.AuthorID,
+			Body:          c.Body,
+			InReplyTo:     c.InReplyTo,
+			QuotedChirpID: c.QuotedChirpID,
+			MediaIDs:      c.MediaIDs,
+			CreatedAt:     time.Now().UTC(),
+			PublishAt:     publishAt,
+		}
+		return tx.log(logEntry{Op: opDraftCreated, Draft: &draft})
+	})
+	if err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// GetDrafts returns a page of the author's scheduled or unscheduled drafts, ordered by id
+func (db *DB) GetDrafts(authorID int, scheduled bool, page Page) ([]Draft, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var ids []int
+	for _, id := range db.index.drafts[authorID] {
+		if (db.data.Drafts[id].PublishAt != nil) == scheduled {
+			ids = append(ids, id)
+		}
+	}
+
+	ids = page.window(ids)
+	drafts := make([]Draft, 0, len(ids))
+	for _, id := range ids {
+		drafts = append(drafts, db.data.Drafts[id])
+	}
+	return drafts, nil
+}
+
+// DeleteDraft discards a draft, or cancels a scheduled one, owned by authorID
+func (db *DB) DeleteDraft(authorID, id int) error {
+	return db.Update(func(tx *Tx) error {
+		draft, ok := tx.db.data.Drafts[id]
+		if !ok || draft.AuthorID != authorID {
+			return ErrDraftNotFound
+		}
+		return tx.log(logEntry{Op: opDraftDeleted, ID: id})
+	})
+}
+
+// DueDrafts returns the drafts scheduled for now or earlier, earliest first
+func (db *DB) DueDrafts(now time.Time) ([]Draft, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var due []Draft
+	for _, draft := range db.data.Drafts {
+		if draft.PublishAt != nil && !draft.PublishAt.After(now) {
+			due = append(due, draft)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].PublishAt.Equal(*due[j].PublishAt) {
+			return due[i].PublishAt.Before(*due[j].PublishAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	return due, nil
+}
+
+// PublishDraft publishes a draft owned by authorID as a chirp and deletes
+// it, both in one transaction. If the chirp it replies to or quotes has been
+// deleted since, the draft is kept, unscheduled and with its PublishError
+// set, and the error is returned.
+func (db *DB) PublishDraft(authorID, id int) (Chirp, error) {
+	var chirp Chirp
+	var publishErr error
+	err := db.Update(func(tx *Tx) error {
+		draft, ok := tx.db.data.Drafts[id]
+		if !ok || draft.AuthorID != authorID {
+			return ErrDraftNotFound
+		}
+
+		var err error
+		chirp, err = tx.createChirp(draft.chirp())
+		if publishFailed(err) {
+			publishErr = err
+			draft.PublishAt = nil
+			draft.PublishError = err.Error()
+			return tx.log(logEntry{Op: opDraftUpdated, Draft: &draft})
+		}
+		if err != nil {
+			return err
+		}
+		return tx.log(logEntry{Op: opDraftDeleted, ID: id})
+	})
+	if err == nil {
+		err = publishErr
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.view(chirp), nil
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrFollowSelf is returned when a user tries to follow themselves
+var ErrFollowSelf = errors.New("users can't follow themselves")
+
+// Follow records one user following another
+type Follow struct {
+	ID         int       `json:"id"`
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// FollowUser is a user in a list of followers or followed users
+type FollowUser struct {
+	// FollowID is the id of the follow, which orders the list
+	FollowID   int       `json:"-"`
+	UserID     int       `json:"id"`
+	Handle     string    `json:"handle"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// FollowCounts is how many followers a user has and how many users they follow
+type FollowCounts struct {
+	Followers int `json:"followers"`
+	Following int `json:"following"`
+}
+
+// followIndex indexes the follows in the dataset
+type followIndex struct {
+	// byPair maps a follower id to the ids of their follows, by followee id
+	byPair map[int]map[int]int
+	// followers and following hold the sorted ids of the follows of and by each user
+	followers map[int][]int
+	following map[int][]int
+}
+
+func (idx *followIndex) add(f Follow) {
+	followees, ok := idx.byPair[f.FollowerID]
+	if !ok {
+		followees = make(map[int]int)
+		idx.byPair[f.FollowerID] = followees
+	}
+	followees[f.FolloweeID] = f.ID
+	idx.followers[f.FolloweeID] = insertID(idx.followers[f.FolloweeID], f.ID)
+	idx.following[f.FollowerID] = insertID(idx.following[f.FollowerID], f.ID)
+}
+
+func (idx *followIndex) remove(f Follow) {
+	delete(idx.byPair[f.FollowerID], f.FolloweeID)
+	if len(idx.byPair[f.FollowerID]) == 0 {
+		delete(idx.byPair, f.FollowerID)
+	}
+	if ids := removeID(idx.followers[f.FolloweeID], f.ID); len(ids) == 0 {
+		delete(idx.followers, f.FolloweeID)
+	} else {
+		idx.followers[f.FolloweeID] = ids
+	}
+	if ids := removeID(idx.following[f.FollowerID], f.ID); len(ids) == 0 {
+		delete(idx.following, f.FollowerID)
+	} else {
+		idx.following[f.FollowerID] = ids
+	}
+}
+
+// Follow makes followerID follow followeeID. Following twice is a no-op.
+func (db *DB) Follow(followerID, followeeID int) error {
+	if followerID == followeeID {
+		return ErrFollowSelf
+	}
+	return db.Update(func(tx *Tx) error {
+		if _, ok := tx.User(followeeID); !ok {
+			return ErrUserNotFound
+		}
+		if tx.db.index.restrictions.blocked(followerID, followeeID) {
+			return ErrBlocked
+		}
+		if _, ok := tx.db.index.follows.byPair[followerID][followeeID]; ok {
+			return nil
+		}
+
+		follow := Follow{
+			ID:         tx.nextID(seqFollows),
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+			CreatedAt:  time.Now().UTC(),
+		}
+		return tx.log(logEntry{Op: opFollowCreated, Follow: &follow})
+	})
+}
+
+// Unfollow stops followerID following followeeID. Unfollowing a user who
+// isn't followed is a no-op.
+func (db *DB) Unfollow(followerID, followeeID int) error {
+	return db.Update(func(tx *Tx) error {
+		if _, ok := tx.User(followeeID); !ok {
+			return ErrUserNotFound
+		}
+		id, ok := tx.db.index.follows.byPair[followerID][followeeID]
+		if !ok {
+			return nil
+		}
+		return tx.log(logEntry{Op: opFollowDeleted, ID: id})
+	})
+}
+
+// GetFollowers returns a page of the users following userID, ordered by when
+// they followed
+func (db *DB) GetFollowers(userID int, page Page) ([]FollowUser, error) {
+	return db.followPage(userID, page, true)
+}
+
+// GetFollowing returns a page of the users userID follows, ordered by when
+// they were followed
+func (db *DB) GetFollowing(userID int, page Page) ([]FollowUser, error) {
+	return db.followPage(userID, page, false)
+}
+
+func (db *DB) followPage(userID int, page Page, followers bool) ([]FollowUser, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	if _, ok := db.data.Users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	ids := db.index.follows.following[userID]
+	if followers {
+		ids = db.index.follows.followers[userID]
+	}
+
+	ids = page.window(ids)
+	users := make([]FollowUser, 0, len(ids))
+	for _, id := range ids {
+		follow := db.data.Follows[id]
+		other := follow.FolloweeID
+		if followers {
+			other = follow.FollowerID
+		}
+		users = append(users, FollowUser{FollowID: id, UserID: other, Handle: db.data.Users[other].Handle, FollowedAt: follow.CreatedAt})
+	}
+	return users, nil
+}
+
+// CountFollows returns how many followers userID has and how many users they follow
+func (db *DB) CountFollows(userID int) (FollowCounts, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	if _, ok := db.data.Users[userID]; !ok {
+		return FollowCounts{}, ErrUserNotFound
+	}
+	return FollowCounts{
+		Followers: len(db.index.follows.followers[userID]),
+		Following: len(db.index.follows.following[userID]),
+	}, nil
+}
+
+// GetTimeline returns a page of the chirps by userID and the users they
+// follow, merged from each author's chirps when it is read
+func (db *DB) GetTimeline(userID int, page Page) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	ids := append([]int(nil), db.index.chirpsByAuthor[userID]...)
+	for followeeID := range db.index.follows.byPair[userID] {
+		ids = append(ids, db.index.chirpsByAuthor[followeeID]...)
+	}
+	sort.Ints(ids)
+	return db.chirpPage(page.window(ids)), nil
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const maxHandleLength = 30
+
+// ErrInvalidHandle is returned when a handle isn't 1-30 letters, digits or underscores
+var ErrInvalidHandle = errors.New("handle must be 1-30 letters, digits or underscores")
+
+// isHandleByte reports whether c may appear in a handle
+func isHandleByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// NormalizeHandle lowercases handle, with or without its leading @, and checks it is valid
+func NormalizeHandle(handle string) (string, error) {
+	handle = strings.ToLower(strings.TrimPrefix(handle, "@"))
+	if len(handle) == 0 || len(handle) > maxHandleLength {
+		return "", ErrInvalidHandle
+	}
+	for i := 0; i < len(handle); i++ {
+		if !isHandleByte(handle[i]) {
+			return "", ErrInvalidHandle
+		}
+	}
+	return handle, nil
+}
+
+// handleFromEmail derives a handle for a user who didn't choose one from the
+// local part of their email, adding a number if taken says it is in use
+func handleFromEmail(email string, taken func(handle string) bool) string {
+	local := strings.ToLower(email)
+	if at := strings.IndexByte(local, '@'); at >= 0 {
+		local = local[:at]
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(local) && b.Len() < maxHandleLength-4; i++ {
+		if isHandleByte(local[i]) {
+			b.WriteByte(local[i])
+		}
+	}
+	base := b.String()
+	if base == "" {
+		base = "user"
+	}
+
+	handle := base
+	for n := 2; taken(handle); n++ {
+		handle = base + strconv.Itoa(n)
+	}
+	return handle
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ID generation strategies selectable through Options.IDs
+const (
+	// IDSequence numbers each entity 1, 2, 3... from a persisted counter, so
+	// ids are never reused even after deletes
+	IDSequence = "sequence"
+	// IDSnowflake generates time-ordered 53-bit ids that don't need shared
+	// state, so several nodes can create records without coordinating. They
+	// stay below 2^53 so JavaScript clients can read them as numbers.
+	IDSnowflake = "snowflake"
+)
+
+// Entity names used as sequence keys; they match the DBStructure JSON keys
+const (
+	seqChirps       = "chirps"
+	seqUsers        = "users"
+	seqTokens       = "revoked_tokens"
+	seqLikes        = "likes"
+	seqRechirps     = "rechirps"
+	seqRevisions    = "revisions"
+	seqDrafts       = "drafts"
+	seqMedia        = "media"
+	seqFollows      = "follows"
+	seqRestrictions = "restrictions"
+)
+
+// snowflakeEpoch is the start of snowflake time, 2023-01-01 UTC
+var snowflakeEpoch = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// A snowflake id is 39 bits of ticks since snowflakeEpoch, enough for 174
+// years, then the node id and a per-tick counter, 53 bits in all
+const (
+	snowflakeTick     = 10 * time.Millisecond
+	snowflakeNodeBits = 6
+	snowflakeSeqBits  = 8
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSeq   = 1<<snowflakeSeqBits - 1
+)
+
+// snowflake generates ids made of snowflakeTicks since snowflakeEpoch, the
+// node id and a per-tick counter
+type snowflake struct {
+	mux      sync.Mutex
+	node     int64
+	lastTick int64
+	seq      int64
+}
+
+func newSnowflake(node int) (*snowflake, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, fmt.Errorf("snowflake node id must be between 0 and %d", snowflakeMaxNode)
+	}
+	return &snowflake{node: int64(node)}, nil
+}
+
+// resume makes s hand out ids above id, one already stored, so a clock set
+// back while the server was down can't repeat it
+func (s *snowflake) resume(id int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if tick := int64(id) >> (snowflakeNodeBits + snowflakeSeqBits); tick >= s.lastTick {
+		s.lastTick = tick
+		s.seq = snowflakeMaxSeq
+	}
+}
+
+func (s *snowflake) next() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := int64(time.Since(snowflakeEpoch) / snowflakeTick)
+	tick := now
+	if tick < s.lastTick {
+		// The clock went backwards, keep counting from the last timestamp
+		tick = s.lastTick
+	}
+	if tick == s.lastTick {
+		s.seq = (s.seq + 1) & snowflakeMaxSeq
+		if s.seq == 0 && now < s.lastTick {
+			// Counter exhausted and the clock is behind, move on to the next
+			// tick rather than wait for the clock to get there
+			tick++
+		} else if s.seq == 0 {
+			// Counter exhausted for this tick, wait for the next one
+			for tick <= s.lastTick {
+				time.Sleep(time.Millisecond)
+				tick = int64(time.Since(snowflakeEpoch) / snowflakeTick)
+			}
+		}
+	} else {
+		s.seq = 0
+	}
+	s.lastTick = tick
+
+	return int(tick<<(snowflakeNodeBits+snowflakeSeqBits) | s.node<<snowflakeSeqBits | s.seq)
+}
+
+// nextID returns the id for a new record of entity. The caller must hold the
+// write lock; the sequence itself only advances when the create is applied.
+func (db *DB) nextID(entity string) int {
+	if db.snowflake != nil {
+		return db.snowflake.next()
+	}
+	return db.data.Sequences[entity] + 1
+}
+
+// bumpSequence makes sure the sequence for entity is at least id
+func (data *DBStructure) bumpSequence(entity string, id int) {
+	if id > data.Sequences[entity] {
+		data.Sequences[entity] = id
+	}
+}
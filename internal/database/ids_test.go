@@ -0,0 +1,30 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSnowflakeResume checks ids stay above those stored by a run whose
+// clock was ahead, without waiting for the clock to catch up
+func TestSnowflakeResume(t *testing.T) {
+	s, err := newSnowflake(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ahead := int64(time.Since(snowflakeEpoch)/snowflakeTick) + int64(time.Hour/snowflakeTick)
+	stored := int(ahead<<(snowflakeNodeBits+snowflakeSeqBits) | 1<<snowflakeSeqBits | snowflakeMaxSeq)
+	s.resume(stored)
+
+	last := stored
+	for i := 0; i < 2*snowflakeMaxSeq; i++ {
+		id := s.next()
+		if id <= last {
+			t.Fatalf("id %d after %d", id, last)
+		}
+		last = id
+	}
+	if last >= 1<<53 {
+		t.Fatalf("id %d doesn't fit in 53 bits", last)
+	}
+}
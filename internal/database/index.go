@@ -0,0 +1,303 @@
+package database
+
+import "sort"
+
+// dbIndex holds secondary indexes over the in-memory dataset so lookups by
+// email, password reset token or author don't scan every record. Chirps by id and users by id are
+// already keyed maps in DBStructure; chirpIDs keeps their ids in order for
+// paging, and terms is the inverted index used by search. Tombstones of
+// deleted chirps are only indexed as replies, to hold their threads together.
+// likes and rechirps find a user's reaction to a chirp and list their likes,
+// and revisions lists the earlier bodies of each chirp. trash lists the
+// chirps each author has in the trash, and drafts their drafts. follows
+// holds who follows whom, and restrictions who blocked or muted whom.
+type dbIndex struct {
+	usersByEmail    map[string]int
+	usersByHandle   map[string]int
+	usersByReset    map[string]int
+	chirpIDs        []int
+	chirpsByAuthor  map[int][]int
+	chirpsByTag     map[string][]int
+	chirpsByMention map[int][]int
+	replies         map[int][]int
+	terms           map[string]postings
+	likes           reactionIndex
+	rechirps        reactionIndex
+	revisions       map[int][]int
+	trash           map[int][]int
+	drafts          map[int][]int
+	follows         followIndex
+	restrictions    restrictionIndex
+}
+
+// reactionIndex indexes the likes or the rechirps in the dataset
+type reactionIndex struct {
+	// byChirp maps a chirp id to the ids of its reactions, by user id
+	byChirp map[int]map[int]int
+	// byUser holds the sorted ids of each user's reactions
+	byUser map[int][]int
+}
+
+// rebuild recreates every index from data
+func (idx *dbIndex) rebuild(data DBStructure) {
+	idx.usersByEmail = make(map[string]int, len(data.Users))
+	idx.usersByHandle = make(map[string]int, len(data.Users))
+	idx.usersByReset = make(map[string]int)
+	idx.chirpIDs = nil
+	idx.chirpsByAuthor = make(map[int][]int)
+	idx.chirpsByTag = make(map[string][]int)
+	idx.chirpsByMention = make(map[int][]int)
+	idx.replies = make(map[int][]int)
+	idx.terms = make(map[string]postings)
+	idx.likes = reactionIndex{byChirp: make(map[int]map[int]int), byUser: make(map[int][]int)}
+	idx.rechirps = reactionIndex{byChirp: make(map[int]map[int]int), byUser: make(map[int][]int)}
+	idx.revisions = make(map[int][]int)
+	idx.trash = make(map[int][]int)
+	idx.drafts = make(map[int][]int)
+	idx.follows = followIndex{byPair: make(map[int]map[int]int), followers: make(map[int][]int), following: make(map[int][]int)}
+	idx.restrictions = restrictionIndex{byKey: make(map[restrictionKey]int), byUser: make(map[int][]int), byTarget: make(map[int][]int)}
+
+	for _, user := range data.Users {
+		idx.putUser(User{}, user, false)
+	}
+	for _, chirp := range data.Chirps {
+		idx.addChirp(chirp)
+	}
+	for _, k := range reactionKinds {
+		for _, reaction := range data.reactions(k) {
+			idx.reactions(k).add(reaction)
+		}
+	}
+	for _, revision := range data.Revisions {
+		idx.revisions[revision.ChirpID] = insertID(idx.revisions[revision.ChirpID], revision.ID)
+	}
+	for _, draft := range data.Drafts {
+		idx.drafts[draft.AuthorID] = insertID(idx.drafts[draft.AuthorID], draft.ID)
+	}
+	for _, follow := range data.Follows {
+		idx.follows.add(follow)
+	}
+	for _, restriction := range data.Restrictions {
+		idx.restrictions.add(restriction)
+	}
+}
+
+// reactions returns the index of reactions of kind k
+func (idx *dbIndex) reactions(k reactionKind) *reactionIndex {
+	if k.entity == seqLikes {
+		return &idx.likes
+	}
+	return &idx.rechirps
+}
+
+func (idx *reactionIndex) add(reaction Reaction) {
+	users, ok := idx.byChirp[reaction.ChirpID]
+	if !ok {
+		users = make(map[int]int)
+		idx.byChirp[reaction.ChirpID] = users
+	}
+	users[reaction.UserID] = reaction.ID
+	idx.byUser[reaction.UserID] = insertID(idx.byUser[reaction.UserID], reaction.ID)
+}
+
+func (idx *reactionIndex) remove(reaction Reaction) {
+	delete(idx.byChirp[reaction.ChirpID], reaction.UserID)
+	if len(idx.byChirp[reaction.ChirpID]) == 0 {
+		delete(idx.byChirp, reaction.ChirpID)
+	}
+	if ids := removeID(idx.byUser[reaction.UserID], reaction.ID); len(ids) == 0 {
+		delete(idx.byUser, reaction.UserID)
+	} else {
+		idx.byUser[reaction.UserID] = ids
+	}
+}
+
+func (idx *dbIndex) addChirp(chirp Chirp) {
+	if chirp.InReplyTo != 0 {
+		idx.replies[chirp.InReplyTo] = insertID(idx.replies[chirp.InReplyTo], chirp.ID)
+	}
+	if chirp.trashed() {
+		idx.trash[chirp.AuthorID] = insertID(idx.trash[chirp.AuthorID], chirp.ID)
+	}
+	if chirp.Deleted {
+		return
+	}
+
+	idx.chirpIDs = insertID(idx.chirpIDs, chirp.ID)
+	idx.chirpsByAuthor[chirp.AuthorID] = insertID(idx.chirpsByAuthor[chirp.AuthorID], chirp.ID)
+	for _, tag := range chirp.Tags {
+		idx.chirpsByTag[tag] = insertID(idx.chirpsByTag[tag], chirp.ID)
+	}
+	for _, userID := range chirp.mentionedUsers() {
+		idx.chirpsByMention[userID] = insertID(idx.chirpsByMention[userID], chirp.ID)
+	}
+	idx.addTerms(chirp)
+}
+
+func (idx *dbIndex) removeChirp(chirp Chirp) {
+	if chirp.InReplyTo != 0 {
+		if ids := removeID(idx.replies[chirp.InReplyTo], chirp.ID); len(ids) == 0 {
+			delete(idx.replies, chirp.InReplyTo)
+		} else {
+			idx.replies[chirp.InReplyTo] = ids
+		}
+	}
+	if chirp.trashed() {
+		if ids := removeID(idx.trash[chirp.AuthorID], chirp.ID); len(ids) == 0 {
+			delete(idx.trash, chirp.AuthorID)
+		} else {
+			idx.trash[chirp.AuthorID] = ids
+		}
+	}
+	if chirp.Deleted {
+		return
+	}
+
+	idx.chirpIDs = removeID(idx.chirpIDs, chirp.ID)
+	ids := removeID(idx.chirpsByAuthor[chirp.AuthorID], chirp.ID)
+	if len(ids) == 0 {
+		delete(idx.chirpsByAuthor, chirp.AuthorID)
+	} else {
+		idx.chirpsByAuthor[chirp.AuthorID] = ids
+	}
+	for _, tag := range chirp.Tags {
+		if ids := removeID(idx.chirpsByTag[tag], chirp.ID); len(ids) == 0 {
+			delete(idx.chirpsByTag, tag)
+		} else {
+			idx.chirpsByTag[tag] = ids
+		}
+	}
+	for _, userID := range chirp.mentionedUsers() {
+		if ids := removeID(idx.chirpsByMention[userID], chirp.ID); len(ids) == 0 {
+			delete(idx.chirpsByMention, userID)
+		} else {
+			idx.chirpsByMention[userID] = ids
+		}
+	}
+	idx.removeTerms(chirp)
+}
+
+func (idx *dbIndex) putUser(old, user User, existed bool) {
+	if existed && emailKey(old.Email) != emailKey(user.Email) {
+		delete(idx.usersByEmail, emailKey(old.Email))
+	}
+	if existed && old.Handle != user.Handle {
+		delete(idx.usersByHandle, old.Handle)
+	}
+	if existed && old.ResetTokenHash != user.ResetTokenHash {
+		delete(idx.usersByReset, old.ResetTokenHash)
+	}
+	idx.usersByEmail[emailKey(user.Email)] = user.ID
+	if user.Handle != "" {
+		idx.usersByHandle[user.Handle] = user.ID
+	}
+	if user.ResetTokenHash != "" {
+		idx.usersByReset[user.ResetTokenHash] = user.ID
+	}
+}
+
+// insertID adds id to the sorted slice ids. New ids are usually the largest,
+// so this is normally an append.
+func insertID(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i < len(ids) && ids[i] == id {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+// removeID removes id from the sorted slice ids
+func removeID(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i == len(ids) || ids[i] != id {
+		return ids
+	}
+	return append(ids[:i], ids[i+1:]...)
+}
+
+// apply applies a logged operation to the dataset and keeps the indexes in step with it
+func (db *DB) apply(e logEntry) error {
+	switch e.Op {
+	case opBatch:
+		for _, op := range e.Batch {
+			if err := db.apply(op); err != nil {
+				return err
+			}
+		}
+		return nil
+	case opChirpCreated, opChirpUpdated:
+		if old, ok := db.data.Chirps[e.Chirp.ID]; ok {
+			db.index.removeChirp(old)
+		}
+		db.index.addChirp(*e.Chirp)
+	case opChirpDeleted:
+		if old, ok := db.data.Chirps[e.ID]; ok {
+			db.index.removeChirp(old)
+		}
+	case opUserCreated, opUserUpdated:
+		old, existed := db.data.Users[e.User.ID]
+		db.index.putUser(old, *e.User, existed)
+	case opUserDeleted:
+		if old, ok := db.data.Users[e.ID]; ok {
+			delete(db.index.usersByEmail, emailKey(old.Email))
+			delete(db.index.usersByHandle, old.Handle)
+			delete(db.index.usersByReset, old.ResetTokenHash)
+		}
+	case opLikeCreated, opRechirpCreated:
+		k := reactionKindOf(e.Op)
+		if old, ok := db.data.reactions(k)[e.Reaction.ID]; ok {
+			db.index.reactions(k).remove(old)
+		}
+		db.index.reactions(k).add(*e.Reaction)
+	case opLikeDeleted, opRechirpDeleted:
+		k := reactionKindOf(e.Op)
+		if old, ok := db.data.reactions(k)[e.ID]; ok {
+			db.index.reactions(k).remove(old)
+		}
+	case opFollowCreated:
+		db.index.follows.add(*e.Follow)
+	case opFollowDeleted:
+		if old, ok := db.data.Follows[e.ID]; ok {
+			db.index.follows.remove(old)
+		}
+	case opRestrictionCreated:
+		db.index.restrictions.add(*e.Restriction)
+	case opRestrictionDeleted:
+		if old, ok := db.data.Restrictions[e.ID]; ok {
+			db.index.restrictions.remove(old)
+		}
+	case opDraftCreated, opDraftUpdated:
+		db.index.drafts[e.Draft.AuthorID] = insertID(db.index.drafts[e.Draft.AuthorID], e.Draft.ID)
+	case opDraftDeleted:
+		if old, ok := db.data.Drafts[e.ID]; ok {
+			if ids := removeID(db.index.drafts[old.AuthorID], old.ID); len(ids) == 0 {
+				delete(db.index.drafts, old.AuthorID)
+			} else {
+				db.index.drafts[old.AuthorID] = ids
+			}
+		}
+	case opRevisionCreated:
+		db.index.revisions[e.Revision.ChirpID] = insertID(db.index.revisions[e.Revision.ChirpID], e.Revision.ID)
+	case opRevisionDeleted:
+		if old, ok := db.data.Revisions[e.ID]; ok {
+			if ids := removeID(db.index.revisions[old.ChirpID], old.ID); len(ids) == 0 {
+				delete(db.index.revisions, old.ChirpID)
+			} else {
+				db.index.revisions[old.ChirpID] = ids
+			}
+		}
+	}
+	return db.data.apply(e)
+}
+
+// chirpPage returns the chirps with the given ids, in order. The caller must hold the lock.
+func (db *DB) chirpPage(ids []int) []Chirp {
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		chirps = append(chirps, db.view(db.data.Chirps[id]))
+	}
+	return chirps
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+const (
+	benchChirps = 100000
+	benchUsers  = 1000
+)
+
+// seedBenchDB fills a database with benchChirps chirps by benchUsers users,
+// skipping the log, and also saves them as a plain snapshot for the scanning
+// benchmarks. It returns the database and the snapshot's path.
+func seedBenchDB(b *testing.B) (*DB, string) {
+	b.Helper()
+	db := newTestDB(b)
+
+	db.mux.Lock()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for id := 1; id <= benchUsers; id++ {
+		db.data.Users[id] = User{ID: id, Email: fmt.Sprintf("user%d@example.com", id), Handle: fmt.Sprintf("user%d", id)}
+	}
+	for id := 1; id <= benchChirps; id++ {
+		db.data.Chirps[id] = Chirp{
+			ID:        id,
+			AuthorID:  id%benchUsers + 1,
+			Body:      fmt.Sprintf("chirp number %d", id),
+			CreatedAt: created.Add(time.Duration(id) * time.Second),
+		}
+	}
+	db.data.Sequences[seqUsers] = benchUsers
+	db.data.Sequences[seqChirps] = benchChirps
+	db.index.rebuild(db.data)
+	data, err := json.Marshal(db.data)
+	db.mux.Unlock()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	path := filepath.Join(b.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		b.Fatal(err)
+	}
+	return db, path
+}
+
+// loadSnapshot reads a whole snapshot from disk, as every read did before the
+// dataset was kept in memory
+func loadSnapshot(b *testing.B, path string) DBStructure {
+	file, err := os.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+
+	var data DBStructure
+	if err := json.NewDecoder(file).Decode(&data); err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+// The scan benchmarks time the old path of loading the file and scanning
+// it, and the indexed ones the in-memory lookups that replaced it.
+
+func BenchmarkGetChirp(b *testing.B) {
+	db, path := seedBenchDB(b)
+	id := benchChirps / 2
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			data := loadSnapshot(b, path)
+			chirps := make([]Chirp, 0, len(data.Chirps))
+			for _, chirp := range data.Chirps {
+				chirps = append(chirps, chirp)
+			}
+			sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
+			if chirps[id-1].ID != id {
+				b.Fatalf("found chirp %d, want %d", chirps[id-1].ID, id)
+			}
+		}
+	})
+	b.Run("indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.GetChirp(id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetUserbyEmail(b *testing.B) {
+	db, path := seedBenchDB(b)
+	email := fmt.Sprintf("user%d@example.com", benchUsers/2)
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			data := loadSnapshot(b, path)
+			found := false
+			for _, user := range data.Users {
+				if user.Email == email {
+					found = true
+					break
+				}
+			}
+			if !found {
+				b.Fatalf("user %s not found", email)
+			}
+		}
+	})
+	b.Run("indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.GetUserbyEmail(email); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetChirpsByAuthor(b *testing.B) {
+	db, path := seedBenchDB(b)
+	authorID := benchUsers / 2
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			data := loadSnapshot(b, path)
+			var chirps []Chirp
+			for _, chirp := range data.Chirps {
+				if chirp.AuthorID == authorID {
+					chirps = append(chirps, chirp)
+				}
+			}
+			sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
+			if len(chirps) != benchChirps/benchUsers {
+				b.Fatalf("got %d chirps, want %d", len(chirps), benchChirps/benchUsers)
+			}
+		}
+	})
+	b.Run("indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			chirps, err := db.GetChirpsByAuthor(authorID, Page{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(chirps) != benchChirps/benchUsers {
+				b.Fatalf("got %d chirps, want %d", len(chirps), benchChirps/benchUsers)
+			}
+		}
+	})
+}
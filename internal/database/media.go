@@ -0,0 +1,105 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// MaxChirpMedia is how many attachments a chirp can carry
+const MaxChirpMedia = 4
+
+// ErrMediaNotFound is returned when no media has the requested id, or it
+// belongs to someone else
+var ErrMediaNotFound = errors.New("media not found")
+
+// ErrTooManyMedia is returned when a chirp has more than MaxChirpMedia attachments
+var ErrTooManyMedia = errors.New("too many media attachments")
+
+// ErrDuplicateMedia is returned when a chirp attaches the same media twice
+var ErrDuplicateMedia = errors.New("media attached more than once")
+
+// Media is an uploaded image. The image and its thumbnail are kept in a blob
+// store under Key and ThumbnailKey; the database only keeps what they are.
+type Media struct {
+	ID            int       `json:"id"`
+	OwnerID       int       `json:"owner_id"`
+	Key           string    `json:"key"`
+	ThumbnailKey  string    `json:"thumbnail_key"`
+	ContentType   string    `json:"content_type"`
+	ThumbnailType string    `json:"thumbnail_type"`
+	Size          int64     `json:"size"`
+	Width         int       `json:"width"`
+	Height        int       `json:"height"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Attachment is the copy of a media's details kept on a chirp that carries it
+type Attachment struct {
+	ID          int    `json:"id"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+func (m Media) attachment() Attachment {
+	return Attachment{ID: m.ID, ContentType: m.ContentType, Width: m.Width, Height: m.Height}
+}
+
+// CreateMedia records an uploaded image, assigning its id and creation time
+func (db *DB) CreateMedia(m Media) (Media, error) {
+	err := db.Update(func(tx *Tx) error {
+		m.ID = tx.nextID(seqMedia)
+		m.CreatedAt = time.Now().UTC()
+		media := m
+		return tx.log(logEntry{Op: opMediaCreated, Media: &media})
+	})
+	if err != nil {
+		return Media{}, err
+	}
+	return m, nil
+}
+
+// GetMedia returns the media with the given id
+func (db *DB) GetMedia(id int) (Media, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	m, ok := db.data.Media[id]
+	if !ok {
+		return Media{}, ErrMediaNotFound
+	}
+	return m, nil
+}
+
+// checkMediaIDs returns an error if a chirp can't attach ids, before looking
+// any of them up
+func checkMediaIDs(ids []int) error {
+	if len(ids) > MaxChirpMedia {
+		return ErrTooManyMedia
+	}
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return ErrDuplicateMedia
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// attachments returns the attachments for the media ids, which authorID
+// must have uploaded
+func (tx *Tx) attachments(authorID int, ids []int) ([]Attachment, error) {
+	if err := checkMediaIDs(ids); err != nil {
+		return nil, err
+	}
+	var attachments []Attachment
+	for _, id := range ids {
+		m, ok := tx.db.data.Media[id]
+		if !ok || m.OwnerID != authorID {
+			return nil, ErrMediaNotFound
+		}
+		attachments = append(attachments, m.attachment())
+	}
+	return attachments, nil
+}
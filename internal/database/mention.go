@@ -0,0 +1,67 @@
+package database
+
+import "strings"
+
+// Mention is an @handle in a chirp's body that resolved to a user. Start and
+// End are the byte offsets of "@handle" in the body.
+type Mention struct {
+	UserID int    `json:"user_id"`
+	Handle string `json:"handle"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+// extractMentions returns every @handle in body, with its offsets but no
+// user. An @ following a handle character, as in an email address, doesn't
+// start a mention.
+func extractMentions(body string) []Mention {
+	var mentions []Mention
+	for i := 0; i < len(body); i++ {
+		if body[i] != '@' || (i > 0 && isHandleByte(body[i-1])) {
+			continue
+		}
+		end := i + 1
+		for end < len(body) && isHandleByte(body[end]) {
+			end++
+		}
+		if handle := body[i+1 : end]; len(handle) > 0 && len(handle) <= maxHandleLength {
+			mentions = append(mentions, Mention{Handle: strings.ToLower(handle), Start: i, End: end})
+		}
+		i = end - 1
+	}
+	return mentions
+}
+
+// resolveMentions keeps the mentions in body whose handle lookup finds a
+// user, leaving the rest as plain text
+func resolveMentions(body string, lookup func(handle string) (int, bool)) []Mention {
+	var resolved []Mention
+	for _, m := range extractMentions(body) {
+		if id, ok := lookup(m.Handle); ok {
+			m.UserID = id
+			resolved = append(resolved, m)
+		}
+	}
+	return resolved
+}
+
+// mentionedUsers returns the distinct users the chirp mentions
+func (chirp Chirp) mentionedUsers() []int {
+	var ids []int
+	seen := map[int]bool{}
+	for _, m := range chirp.Mentions {
+		if !seen[m.UserID] {
+			seen[m.UserID] = true
+			ids = append(ids, m.UserID)
+		}
+	}
+	return ids
+}
+
+// GetMentions returns a page of the chirps that mention the user, ordered by id
+func (db *DB) GetMentions(userID int, page Page) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.chirpPage(page.window(db.index.chirpsByMention[userID])), nil
+}
@@ -0,0 +1,735 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Migrator is implemented by stores with a versioned schema. Stores upgrade
+// themselves to the latest version when they are opened; the methods here
+// back the `chirpy migrate` command.
+type Migrator interface {
+	MigrationStatus() ([]MigrationStatus, error)
+	MigrateUp() error
+	MigrateDown() error
+}
+
+// MigrationStatus describes a single migration and whether it has been applied
+type MigrationStatus struct {
+	Version int
+	Name    string
+	Applied bool
+}
+
+// OpenMigrator opens the store named by driver at path without upgrading its schema
+func OpenMigrator(driver, path string, opts Options) (Migrator, io.Closer, error) {
+	switch driver {
+	case "", "json":
+		db, err := openDB(path, opts, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, db, nil
+	case "sqlite":
+		if opts.MasterKey != nil {
+			return nil, nil, errEncryptionUnsupported
+		}
+		db, err := openSQLiteDB(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, db, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
+
+// jsonDocument is the database file decoded one level deep, so migrations can
+// reshape it without depending on the current DBStructure
+type jsonDocument map[string]json.RawMessage
+
+// jsonMigration upgrades (Up) or downgrades (Down) the JSON database file by one version
+type jsonMigration struct {
+	Name string
+	Up   func(doc jsonDocument) error
+	Down func(doc jsonDocument) error
+}
+
+// jsonMigrations is the ordered list of JSON file migrations. The schema
+// version of a file is the number of migrations applied to it; never reorder
+// or remove entries, only append.
+var jsonMigrations = []jsonMigration{
+	{
+		Name: "create chirps and users",
+		Up: func(doc jsonDocument) error {
+			doc.ensureObject("chirps")
+			doc.ensureObject("users")
+			return nil
+		},
+		Down: func(doc jsonDocument) error {
+			delete(doc, "chirps")
+			delete(doc, "users")
+			return nil
+		},
+	},
+	{
+		Name: "add revoked tokens",
+		Up: func(doc jsonDocument) error {
+			doc.ensureObject("revoked_tokens")
+			return nil
+		},
+		Down: func(doc jsonDocument) error {
+			delete(doc, "revoked_tokens")
+			return nil
+		},
+	},
+	{
+		Name: "add id sequences",
+		Up: func(doc jsonDocument) error {
+			// Start each sequence after the highest id in use so ids
+			// handed out before this migration are never reused
+			sequences := map[string]int{}
+			for _, entity := range []string{seqChirps, seqUsers, seqTokens} {
+				var records map[int]json.RawMessage
+				if err := json.Unmarshal(doc[entity], &records); err != nil {
+					return err
+				}
+				sequences[entity] = 0
+				for id := range records {
+					if id > sequences[entity] {
+						sequences[entity] = id
+					}
+				}
+			}
+			raw, err := json.Marshal(sequences)
+			if err != nil {
+				return err
+			}
+			doc["sequences"] = raw
+			return nil
+		},
+		Down: func(doc jsonDocument) error {
+			delete(doc, "sequences")
+			return nil
+		},
+	},
+	{
+		Name: "add chirp timestamps",
+		Up: func(doc jsonDocument) error {
+			// The real creation time of older chirps is unknown, so they
+			// are stamped with the time of the upgrade
+			now, err := json.Marshal(time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			return doc.updateRecords("chirps", func(chirp map[string]json.RawMessage) error {
+				if _, ok := chirp["created_at"]; !ok {
+					chirp["created_at"] = now
+				}
+				return nil
+			})
+		},
+		Down: func(doc jsonDocument) error {
+			return doc.updateRecords("chirps", func(chirp map[string]json.RawMessage) error {
+				delete(chirp, "created_at")
+				return nil
+			})
+		},
+	},
+	{
+		Name: "add chirp tags",
+		Up: func(doc jsonDocument) error {
+			return doc.updateRecords("chirps", func(chirp map[string]json.RawMessage) error {
+				var body string
+				if err := json.Unmarshal(chirp["body"], &body); err != nil {
+					return err
+				}
+				tags := migrationTags(body)
+				if tags == nil {
+					return nil
+				}
+				raw, err := json.Marshal(tags)
+				chirp["tags"] = raw
+				return err
+			})
+		},
+		Down: func(doc jsonDocument) error {
+			return doc.updateRecords("chirps", func(chirp map[string]json.RawMessage) error {
+				delete(chirp, "tags")
+				return nil
+			})
+		},
+	},
+	{
+		// Mentions are resolved when a chirp is created, so existing
+		// chirps get none; only users need filling in
+		Name: "add user handles and mentions",
+		Up: func(doc jsonDocument) error {
+			var users map[int]migrationUser
+			if err := json.Unmarshal(doc["users"], &users); err != nil {
+				return err
+			}
+			ids := make([]int, 0, len(users))
+			for id := range users {
+				ids = append(ids, id)
+			}
+			sort.Ints(ids)
+
+			// Earlier users get first pick of the handle derived from
+			// their email
+			taken := map[string]bool{}
+			handles := map[int]string{}
+			for _, id := range ids {
+				handle := migrationHandle(users[id].Email, func(h string) bool { return taken[h] })
+				taken[handle] = true
+				handles[id] = handle
+			}
+
+			return doc.updateRecords("users", func(user map[string]json.RawMessage) error {
+				var id int
+				if err := json.Unmarshal(user["id"], &id); err != nil {
+					return err
+				}
+				raw, err := json.Marshal(handles[id])
+				user["handle"] = raw
+				return err
+			})
+		},
+		Down: func(doc jsonDocument) error {
+			err := doc.updateRecords("chirps", func(chirp map[string]json.RawMessage) error {
+				delete(chirp, "mentions")
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			return doc.updateRecords("users", func(user map[string]json.RawMessage) error {
+				delete(user, "handle")
+				return nil
+			})
+		},
+	},
+	{
+		// Existing chirps are not replies, so there is nothing to fill in
+		Name: "add chirp replies",
+		Up:   func(doc jsonDocument) error { return nil },
+		Down: func(doc jsonDocument) error {
+			var chirps map[string]map[string]json.RawMessage
+			if err := json.Unmarshal(doc["chirps"], &chirps); err != nil {
+				return err
+			}
+			for key, chirp := range chirps {
+				if string(chirp["deleted"]) == "true" {
+					delete(chirps, key)
+				}
+				delete(chirp, "in_reply_to")
+				delete(chirp, "deleted")
+			}
+			raw, err := json.Marshal(chirps)
+			doc["chirps"] = raw
+			return err
+		},
+	},
+	{
+		// Nothing has been liked or rechirped yet, so every count starts at 0
+		Name: "add likes and rechirps",
+		Up: func(doc jsonDocument) error {
+			doc.ensureObject("likes")
+			doc.ensureObject("rechirps")
+			return nil
+		},
+		Down: func(doc jsonDocument) error {
+			delete(doc, "likes")
+			delete(doc, "rechirps")
+			if err := doc.deleteSequences(seqLikes, seqRechirps); err != nil {
+				return err
+			}
+			return doc.updateRecords("chirps", func(chirp map[string]json.RawMessage) error {
+				delete(chirp, "like_count")
+				delete(chirp, "rechirp_count")
+				return nil
+			})
+		},
+	},
+	{
+		// Existing chirps don't quote anything, so there is nothing to fill in
+		Name: "add quote chirps",
+		Up:   func(doc jsonDocument) error { return nil },
+		Down: func(doc jsonDocument) error {
+			return doc.updateRecords("chirps", func(chirp map[string]json.RawMessage) error {
+				delete(chirp, "quoted_chirp_id")
+				return nil
+			})
+		},
+	},
+	{
+		// No chirp has been edited yet, so there are no revisions to fill in
+		Name: "add chirp revisions",
+		Up: func(doc jsonDocument) error {
+			doc.ensureObject("revisions")
+			return nil
+		},
+		Down: func(doc jsonDocument) error {
+			delete(doc, "revisions")
+			if err := doc.deleteSequences(seqRevisions); err != nil {
+				return err
+			}
+			return doc.updateRecords("chirps", func(chirp map[string]json.RawMessage) error {
+				delete(chirp, "edited_at")
+				return nil
+			})
+		},
+	},
+	{
+		// Older versions have no trash, so chirps in it are left behind as
+		// tombstones when downgrading
+		Name: "add chirp trash",
+		Up:   func(doc jsonDocument) error { return nil },
+		Down: func(doc jsonDocument) error {
+			return doc.updateRecords("chirps", func(chirp map[string]json.RawMessage) error {
+				if _, ok := chirp["deleted_at"]; !ok {
+					return nil
+				}
+				for key := range chirp {
+					switch key {
+					case "id", "created_at", "in_reply_to", "deleted":
+					default:
+						delete(chirp, key)
+					}
+				}
+				return nil
+			})
+		},
+	},
+	{
+		Name: "add drafts",
+		Up: func(doc jsonDocument) error {
+			doc.ensureObject("drafts")
+			return nil
+		},
+		Down: func(doc jsonDocument) error {
+			delete(doc, "drafts")
+			return doc.deleteSequences(seqDrafts)
+		},
+	},
+	{
+		// Downgrading drops the attachments from chirps and drafts
+		Name: "add media",
+		Up: func(doc jsonDocument) error {
+			doc.ensureObject("media")
+			return nil
+		},
+		Down: func(doc jsonDocument) error {
+			if err := doc.updateRecords("chirps", func(chirp map[string]json.RawMessage) error {
+				delete(chirp, "media")
+				return nil
+			}); err != nil {
+				return err
+			}
+			if err := doc.updateRecords("drafts", func(draft map[string]json.RawMessage) error {
+				delete(draft, "media_ids")
+				return nil
+			}); err != nil {
+				return err
+			}
+			delete(doc, "media")
+			return doc.deleteSequences(seqMedia)
+		},
+	},
+	{
+		Name: "add follows",
+		Up: func(doc jsonDocument) error {
+			doc.ensureObject("follows")
+			return nil
+		},
+		Down: func(doc jsonDocument) error {
+			delete(doc, "follows")
+			return doc.deleteSequences(seqFollows)
+		},
+	},
+	{
+		Name: "add restrictions",
+		Up: func(doc jsonDocument) error {
+			doc.ensureObject("restrictions")
+			return nil
+		},
+		Down: func(doc jsonDocument) error {
+			delete(doc, "restrictions")
+			return doc.deleteSequences(seqRestrictions)
+		},
+	},
+	{
+		// When existing users signed up isn't recorded, so they get the
+		// time of the migration
+		Name: "add user profiles",
+		Up: func(doc jsonDocument) error {
+			now, err := json.Marshal(time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			return doc.updateRecords("users", func(user map[string]json.RawMessage) error {
+				user["display_name"] = json.RawMessage(`""`)
+				user["bio"] = json.RawMessage(`""`)
+				user["created_at"] = now
+				return nil
+			})
+		},
+		Down: func(doc jsonDocument) error {
+			return doc.updateRecords("users", func(user map[string]json.RawMessage) error {
+				for _, key := range []string{"display_name", "bio", "avatar_id", "created_at"} {
+					delete(user, key)
+				}
+				return nil
+			})
+		},
+	},
+	{
+		// Users who signed up before emails were verified are trusted
+		Name: "add email verification",
+		Up: func(doc jsonDocument) error {
+			return doc.updateRecords("users", func(user map[string]json.RawMessage) error {
+				user["email_verified"] = json.RawMessage(`true`)
+				return nil
+			})
+		},
+		Down: func(doc jsonDocument) error {
+			return doc.updateRecords("users", func(user map[string]json.RawMessage) error {
+				for _, key := range []string{"email_verified", "verification_id", "verification_sent_at"} {
+					delete(user, key)
+				}
+				return nil
+			})
+		},
+	},
+	{
+		Name: "add password resets",
+		Up:   func(doc jsonDocument) error { return nil },
+		Down: func(doc jsonDocument) error {
+			return doc.updateRecords("users", func(user map[string]json.RawMessage) error {
+				for _, key := range []string{"reset_token_hash", "reset_sent_at", "tokens_valid_after"} {
+					delete(user, key)
+				}
+				return nil
+			})
+		},
+	},
+}
+
+// updateRecords calls fn on every record of the entity key, decoded one level deep
+func (doc jsonDocument) updateRecords(key string, fn func(record map[string]json.RawMessage) error) error {
+	var records map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(doc[key], &records); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	raw, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	doc[key] = raw
+	return nil
+}
+
+// deleteSequences removes the id sequences of entities
+func (doc jsonDocument) deleteSequences(entities ...string) error {
+	raw, ok := doc["sequences"]
+	if !ok {
+		return nil
+	}
+	var sequences map[string]int
+	if err := json.Unmarshal(raw, &sequences); err != nil {
+		return err
+	}
+	for _, entity := range entities {
+		delete(sequences, entity)
+	}
+	raw, err := json.Marshal(sequences)
+	if err != nil {
+		return err
+	}
+	doc["sequences"] = raw
+	return nil
+}
+
+// ensureObject sets key to an empty object if it is missing or null
+func (doc jsonDocument) ensureObject(key string) {
+	if raw, ok := doc[key]; !ok || string(raw) == "null" {
+		doc[key] = json.RawMessage("{}")
+	}
+}
+
+func (doc jsonDocument) version() (int, error) {
+	raw, ok := doc["schema_version"]
+	if !ok {
+		return 0, nil
+	}
+	var version int
+	err := json.Unmarshal(raw, &version)
+	return version, err
+}
+
+func (doc jsonDocument) setVersion(version int) {
+	doc["schema_version"] = json.RawMessage(strconv.Itoa(version))
+}
+
+// MigrationStatus lists every JSON migration and whether the file has it applied
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	doc, err := db.readDocument()
+	if err != nil {
+		return nil, err
+	}
+	current, err := doc.version()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(jsonMigrations))
+	for i, m := range jsonMigrations {
+		status[i] = MigrationStatus{Version: i + 1, Name: m.Name, Applied: i < current}
+	}
+	return status, nil
+}
+
+// MigrateUp applies every pending migration to the database file
+func (db *DB) MigrateUp() error {
+	return db.migrate(func(doc jsonDocument, current int) (int, error) {
+		if current > len(jsonMigrations) {
+			return current, errors.New("database file is newer than this version of chirpy")
+		}
+		for v := current; v < len(jsonMigrations); v++ {
+			if err := jsonMigrations[v].Up(doc); err != nil {
+				return v, err
+			}
+		}
+		return len(jsonMigrations), nil
+	})
+}
+
+// MigrateDown reverts the most recently applied migration
+func (db *DB) MigrateDown() error {
+	return db.migrate(func(doc jsonDocument, current int) (int, error) {
+		if current == 0 {
+			return 0, errors.New("no migrations to revert")
+		}
+		if current > len(jsonMigrations) {
+			return current, errors.New("database file is newer than this version of chirpy")
+		}
+		if err := jsonMigrations[current-1].Down(doc); err != nil {
+			return current, err
+		}
+		return current - 1, nil
+	})
+}
+
+// migrate runs step against the database file under the write lock and
+// writes the result back if the version changed. The database must not be
+// loaded yet.
+func (db *DB) migrate(step func(doc jsonDocument, current int) (int, error)) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	doc, err := db.readDocument()
+	if err != nil {
+		return err
+	}
+	current, err := doc.version()
+	if err != nil {
+		return err
+	}
+
+	// Migrations only rewrite the snapshot, so fold the log into it first or
+	// it would be replayed on a schema it wasn't written for. A log is only
+	// written against the latest schema; one left next to an older file was
+	// written by an older version, and is replayed once the file is upgraded.
+	if current == len(jsonMigrations) {
+		folded, err := db.foldLog()
+		if err != nil {
+			return err
+		}
+		if folded {
+			if doc, err = db.readDocument(); err != nil {
+				return err
+			}
+		}
+	}
+
+	next, err := step(doc, current)
+	if err != nil {
+		return err
+	}
+	if next == current {
+		return nil
+	}
+	doc.setVersion(next)
+
+	return db.writeDocument(doc)
+}
+
+// foldLog replays the operation log into the snapshot and removes it,
+// reporting whether there was anything to fold. The caller must hold the
+// write lock.
+func (db *DB) foldLog() (bool, error) {
+	var logs []string
+	for _, path := range []string{db.oldWALPath(), db.walPath()} {
+		if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+			logs = append(logs, path)
+		} else if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	if len(logs) == 0 {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return false, err
+	}
+	if data, err = db.env.decodeFile(data); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, &db.data); err != nil {
+		return false, err
+	}
+	db.index.rebuild(db.data)
+	db.seq = db.data.LogSequence
+	for _, path := range logs {
+		if err := db.replay(path); err != nil {
+			return false, err
+		}
+	}
+	db.data.LogSequence = db.seq
+	db.logged = 0
+
+	data, err = json.MarshalIndent(db.data, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if data, err = db.env.encodeFile(append(data, '\n')); err != nil {
+		return false, err
+	}
+	if err := writeFileAtomic(db.path, data); err != nil {
+		return false, err
+	}
+	for _, path := range []string{db.oldWALPath(), db.walPath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// readDocument reads the raw database file, treating an empty file as an empty document
+func (db *DB) readDocument() (jsonDocument, error) {
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = db.env.decodeFile(data)
+	if err != nil {
+		return nil, err
+	}
+	doc := jsonDocument{}
+	if len(data) == 0 {
+		return doc, nil
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (db *DB) writeDocument(doc jsonDocument) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	data, err = db.env.encodeFile(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(db.path, data)
+}
+
+// Migrations must keep doing what they did when they were written, however
+// the code they relied on has changed since, so they use these frozen copies
+// of it. Never change them; copy anything a new migration needs instead.
+
+// migrationUser is the part of a user the handles migration reads
+type migrationUser struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+}
+
+// migrationTags is extractTags as it was when chirp tags were added
+func migrationTags(body string) []string {
+	isTagRune := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_'
+	}
+
+	var tags []string
+	seen := map[string]bool{}
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' || (i > 0 && isTagRune(runes[i-1])) {
+			continue
+		}
+		end := i + 1
+		hasLetter := false
+		for end < len(runes) && isTagRune(runes[end]) {
+			hasLetter = hasLetter || unicode.IsLetter(runes[end])
+			end++
+		}
+		if hasLetter {
+			tag := strings.ToLower(string(runes[i+1 : end]))
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		i = end - 1
+	}
+	return tags
+}
+
+// migrationHandle is handleFromEmail as it was when user handles were added
+func migrationHandle(email string, taken func(handle string) bool) string {
+	const maxLength = 30
+
+	local := strings.ToLower(email)
+	if at := strings.IndexByte(local, '@'); at >= 0 {
+		local = local[:at]
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(local) && b.Len() < maxLength-4; i++ {
+		if c := local[i]; c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' {
+			b.WriteByte(c)
+		}
+	}
+	base := b.String()
+	if base == "" {
+		base = "user"
+	}
+
+	handle := base
+	for n := 2; taken(handle); n++ {
+		handle = base + strconv.Itoa(n)
+	}
+	return handle
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// baselineFile is a database file as written before schema versions
+const baselineFile = `{
+  "chirps": {"1": {"id": 1, "author_id": 1, "body": "hello #go"}},
+  "users": {"1": {"id": 1, "email": "a@example.com", "password": "", "is_chirpy_red": true}},
+  "revoked_tokens": {}
+}`
+
+// migrateTo migrates the database file at path down to version, or up to
+// the latest
+func migrateTo(t *testing.T, path string, version int) {
+	t.Helper()
+	m, closer, err := OpenMigrator("json", path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	current := func() int {
+		t.Helper()
+		status, err := m.MigrationStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		applied := 0
+		for _, s := range status {
+			if s.Applied {
+				applied = s.Version
+			}
+		}
+		return applied
+	}
+	for v := current(); v > version; v-- {
+		if err := m.MigrateDown(); err != nil {
+			t.Fatalf("migrating down from version %d: %v", v, err)
+		}
+	}
+	if current() < version {
+		if err := m.MigrateUp(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := current(); v != version {
+		t.Fatalf("database file is at version %d, want %d", v, version)
+	}
+}
+
+// TestMigrateBaseline upgrades a file written before schema versions, then
+// migrates it all the way down and back up
+func TestMigrateBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	if err := os.WriteFile(path, []byte(baselineFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(wantChirps int) {
+		t.Helper()
+		db, err := NewDB(path, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		chirps, err := db.GetChirps(Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chirps) != wantChirps {
+			t.Fatalf("got %d chirps, want %d", len(chirps), wantChirps)
+		}
+		if wantChirps == 0 {
+			return
+		}
+		if tags := chirps[0].Tags; len(tags) != 1 || tags[0] != "go" {
+			t.Fatalf("chirp has tags %v, want [go]", tags)
+		}
+		user, err := db.GetUser(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Handle != "a" || !user.Membership || !user.EmailVerified {
+			t.Fatalf("user migrated to %+v", user)
+		}
+	}
+	check(1)
+
+	// Version 1 still has the chirps and users
+	migrateTo(t, path, 1)
+	migrateTo(t, path, len(jsonMigrations))
+	check(1)
+
+	migrateTo(t, path, 0)
+	m, closer, err := OpenMigrator("json", path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MigrateDown(); err == nil {
+		t.Fatal("migrating down from version 0 succeeded")
+	}
+	closer.Close()
+	migrateTo(t, path, len(jsonMigrations))
+	check(0)
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Page selects a window of a list ordered by id. A page starts after the id
+// of the last record of the previous page rather than at an offset, so its
+// boundary stays put when records are inserted or deleted.
+type Page struct {
+	// After is the id of the last record of the previous page, 0 for the first page
+	After int
+	// Limit caps the number of records returned, 0 for no limit
+	Limit int
+	// Desc orders the list newest first
+	Desc bool
+}
+
+// window returns the ids of ids, which must be sorted ascending, that fall in the page
+func (p Page) window(ids []int) []int {
+	var out []int
+	if p.Desc {
+		end := len(ids)
+		if p.After != 0 {
+			end = sort.SearchInts(ids, p.After)
+		}
+		for i := end - 1; i >= 0 && (p.Limit == 0 || len(out) < p.Limit); i-- {
+			out = append(out, ids[i])
+		}
+		return out
+	}
+
+	start := 0
+	if p.After != 0 {
+		start = sort.Search(len(ids), func(i int) bool { return ids[i] > p.After })
+	}
+	end := len(ids)
+	if p.Limit != 0 && start+p.Limit < end {
+		end = start + p.Limit
+	}
+	return append(out, ids[start:end]...)
+}
+
+// sql returns the WHERE condition, ORDER BY and LIMIT clauses selecting the
+// page from a query on column, with their arguments. where is the query's own
+// condition, if any.
+func (p Page) sql(column, where string, args ...interface{}) (string, []interface{}) {
+	op, order := ">", "ASC"
+	if p.Desc {
+		op, order = "<", "DESC"
+	}
+
+	clause := ""
+	if where != "" {
+		clause = " WHERE " + where
+	}
+	if p.After != 0 {
+		if clause == "" {
+			clause = " WHERE "
+		} else {
+			clause += " AND "
+		}
+		clause += fmt.Sprintf("%s %s ?", column, op)
+		args = append(args, p.After)
+	}
+
+	limit := p.Limit
+	if limit == 0 {
+		limit = -1
+	}
+	clause += fmt.Sprintf(" ORDER BY %s %s LIMIT ?", column, order)
+	return clause, append(args, limit)
+}
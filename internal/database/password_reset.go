@@ -0,0 +1,105 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrResetTooSoon is returned when a password reset email was sent too
+	// recently to send another
+	ErrResetTooSoon = errors.New("password reset email sent too recently")
+	// ErrInvalidPasswordReset is returned for a password reset token that
+	// isn't the user's latest, has been used or has expired
+	ErrInvalidPasswordReset = errors.New("invalid or expired password reset token")
+)
+
+// hashResetToken returns the hash a password reset token is stored as, so
+// a copy of the database can't be used to reset passwords
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startPasswordReset checks a password reset email can be sent to the user
+// and records token as the only one that can reset their password
+func (user *User) startPasswordReset(token string, now time.Time, minInterval time.Duration) error {
+	if user.ResetSentAt != nil && now.Sub(*user.ResetSentAt) < minInterval {
+		return ErrResetTooSoon
+	}
+	user.ResetTokenHash = hashResetToken(token)
+	user.ResetSentAt = &now
+	return nil
+}
+
+// resetPassword sets the user's password hash if their reset token was sent
+// less than ttl before now, using the token up. Refresh tokens issued before
+// now stop working.
+func (user *User) resetPassword(hashedPassword []byte, now time.Time, ttl time.Duration) error {
+	if user.ResetTokenHash == "" || user.ResetSentAt == nil || now.Sub(*user.ResetSentAt) >= ttl {
+		return ErrInvalidPasswordReset
+	}
+	user.Password = string(hashedPassword)
+	user.ResetTokenHash = ""
+	user.TokensValidAfter = &now
+	return nil
+}
+
+// TokenRevoked reports whether a refresh token issued to the user at
+// issuedAt was revoked by a password reset
+func (user User) TokenRevoked(issuedAt time.Time) bool {
+	return user.TokensValidAfter != nil && issuedAt.Before(*user.TokensValidAfter)
+}
+
+// StartPasswordReset records token as the one that resets the password of
+// the user with email, replacing any sent before, unless the last one was
+// sent less than minInterval before now. Only a hash of token is stored.
+func (db *DB) StartPasswordReset(email, token string, now time.Time, minInterval time.Duration) (User, error) {
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.UserByEmail(email)
+		if !ok {
+			return ErrUserNotFound
+		}
+		if err := user.startPasswordReset(token, now, minInterval); err != nil {
+			return err
+		}
+		return tx.log(logEntry{Op: opUserUpdated, User: &user})
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// ResetPassword sets a new password for the user token was sent to, if it
+// is their latest and was sent less than ttl before now. Each token works
+// once, and the user's refresh tokens issued before it are revoked.
+func (db *DB) ResetPassword(token, password string, now time.Time, ttl time.Duration) (User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	var user User
+	err = db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.UserByResetToken(hashResetToken(token))
+		if !ok {
+			return ErrInvalidPasswordReset
+		}
+		if err := user.resetPassword(hashedPassword, now, ttl); err != nil {
+			return err
+		}
+		return tx.log(logEntry{Op: opUserUpdated, User: &user})
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
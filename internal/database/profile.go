@@ -0,0 +1,156 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Longest display name and bio a user can set, in characters
+const (
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+)
+
+var (
+	// ErrHandleTaken is returned when a user picks a handle someone else has
+	ErrHandleTaken        = errors.New("handle is already taken")
+	ErrDisplayNameTooLong = fmt.Errorf("display name must be at most %d characters", MaxDisplayNameLength)
+	ErrBioTooLong         = fmt.Errorf("bio must be at most %d characters", MaxBioLength)
+)
+
+// Profile is what anyone can see of a user. It never holds their email or
+// password.
+type Profile struct {
+	ID          int         `json:"id"`
+	Handle      string      `json:"handle"`
+	DisplayName string      `json:"display_name"`
+	Bio         string      `json:"bio"`
+	Avatar      *Attachment `json:"avatar,omitempty"`
+	Membership  bool        `json:"is_chirpy_red"`
+	CreatedAt   time.Time   `json:"created_at"`
+	ChirpCount  int         `json:"chirp_count"`
+	FollowCounts
+}
+
+// profile returns the public fields of the user, without the counts
+func (user User) profile() Profile {
+	return Profile{
+		ID:          user.ID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		Membership:  user.Membership,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+// ProfileUpdate holds the changes to a user's profile. Nil fields are left
+// as they are.
+type ProfileUpdate struct {
+	Handle      *string
+	DisplayName *string
+	Bio         *string
+	// AvatarID is the id of an image the user uploaded, or 0 to remove it
+	AvatarID *int
+}
+
+// normalize checks the update, normalizing the handle and trimming the
+// display name and bio
+func (u *ProfileUpdate) normalize() error {
+	if u.Handle != nil {
+		handle, err := NormalizeHandle(*u.Handle)
+		if err != nil {
+			return err
+		}
+		u.Handle = &handle
+	}
+	if u.DisplayName != nil {
+		name := strings.TrimSpace(*u.DisplayName)
+		if utf8.RuneCountInString(name) > MaxDisplayNameLength {
+			return ErrDisplayNameTooLong
+		}
+		u.DisplayName = &name
+	}
+	if u.Bio != nil {
+		bio := strings.TrimSpace(*u.Bio)
+		if utf8.RuneCountInString(bio) > MaxBioLength {
+			return ErrBioTooLong
+		}
+		u.Bio = &bio
+	}
+	return nil
+}
+
+// apply sets the changed fields on user
+func (u ProfileUpdate) apply(user *User) {
+	if u.Handle != nil {
+		user.Handle = *u.Handle
+	}
+	if u.DisplayName != nil {
+		user.DisplayName = *u.DisplayName
+	}
+	if u.Bio != nil {
+		user.Bio = *u.Bio
+	}
+	if u.AvatarID != nil {
+		user.AvatarID = *u.AvatarID
+	}
+}
+
+// GetProfile returns the public profile of the user, with how many chirps
+// they have posted and their follow counts
+func (db *DB) GetProfile(userID int) (Profile, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return Profile{}, ErrUserNotFound
+	}
+	profile := user.profile()
+	if m, ok := db.data.Media[user.AvatarID]; ok && user.AvatarID != 0 {
+		avatar := m.attachment()
+		profile.Avatar = &avatar
+	}
+	profile.ChirpCount = len(db.index.chirpsByAuthor[userID])
+	profile.Followers = len(db.index.follows.followers[userID])
+	profile.Following = len(db.index.follows.following[userID])
+	return profile, nil
+}
+
+// UpdateProfile changes the user's handle, display name, bio or avatar. The
+// avatar must be an image the user uploaded.
+func (db *DB) UpdateProfile(userID int, u ProfileUpdate) (User, error) {
+	if err := u.normalize(); err != nil {
+		return User{}, err
+	}
+
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userID)
+		if !ok {
+			return ErrUserNotFound
+		}
+		if u.Handle != nil {
+			if other, ok := tx.UserByHandle(*u.Handle); ok && other.ID != userID {
+				return ErrHandleTaken
+			}
+		}
+		if u.AvatarID != nil && *u.AvatarID != 0 {
+			if _, err := tx.attachments(userID, []int{*u.AvatarID}); err != nil {
+				return err
+			}
+		}
+
+		u.apply(&user)
+		return tx.log(logEntry{Op: opUserUpdated, User: &user})
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
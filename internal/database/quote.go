@@ -0,0 +1,67 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrQuotedNotFound is returned when the chirp being quoted doesn't exist
+var ErrQuotedNotFound = errors.New("chirp being quoted not found")
+
+// QuotedChirp is the compact copy of a quoted chirp embedded in the chirp
+// quoting it. It is filled in when the chirp is read, so it always shows the
+// quoted chirp as it is now.
+type QuotedChirp struct {
+	ID        int       `json:"id"`
+	AuthorID  int       `json:"author_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	// Unavailable is set, and nothing but the id kept, once the quoted
+	// chirp has been deleted
+	Unavailable bool `json:"unavailable,omitempty"`
+}
+
+// MarshalJSON encodes an unavailable quote as a placeholder with just its id
+func (q QuotedChirp) MarshalJSON() ([]byte, error) {
+	if q.Unavailable {
+		return json.Marshal(struct {
+			ID          int  `json:"id"`
+			Unavailable bool `json:"unavailable"`
+		}{q.ID, true})
+	}
+	type quotedChirp QuotedChirp
+	return json.Marshal(quotedChirp(q))
+}
+
+// quote returns the embed of chirp, found is false if it was deleted
+func quote(id int, chirp Chirp, found bool) *QuotedChirp {
+	if !found || chirp.Deleted {
+		return &QuotedChirp{ID: id, Unavailable: true}
+	}
+	return &QuotedChirp{
+		ID:        chirp.ID,
+		AuthorID:  chirp.AuthorID,
+		Body:      chirp.Body,
+		CreatedAt: chirp.CreatedAt,
+	}
+}
+
+// view returns the chirp as it is served: deleted chirps as tombstones, others
+// with their quoted chirp embedded. Chirps are stored without the embed, so
+// views must never be logged. The caller must hold the lock.
+func (db *DB) view(chirp Chirp) Chirp {
+	if chirp.Deleted {
+		return chirp.tombstone()
+	}
+	return db.withQuote(chirp)
+}
+
+// withQuote returns the chirp with its quoted chirp embedded. The caller must hold the lock.
+func (db *DB) withQuote(chirp Chirp) Chirp {
+	if chirp.QuotedChirpID != 0 {
+		quoted, ok := db.data.Chirps[chirp.QuotedChirpID]
+		chirp.Quoted = quote(chirp.QuotedChirpID, quoted, ok)
+	}
+	return chirp
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Kinds of reaction a user can leave on a chirp
+const (
+	ReactionLike    = "like"
+	ReactionRechirp = "rechirp"
+)
+
+// Reaction records a user liking or rechirping a chirp. A user reacts to a
+// chirp in each way at most once.
+type Reaction struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	ChirpID   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LikedChirp is a chirp in a user's list of likes
+type LikedChirp struct {
+	Chirp
+	// LikeID is the id of the like, which orders the list
+	LikeID  int       `json:"-"`
+	LikedAt time.Time `json:"liked_at"`
+}
+
+// reactionKind describes how one kind of reaction is stored
+type reactionKind struct {
+	// entity names the id sequence and the SQLite table of the reactions
+	entity string
+	// created and deleted are the log operations adding and removing one
+	created, deleted string
+	// countColumn is the chirps column counting them in SQLite
+	countColumn string
+}
+
+var reactionKinds = map[string]reactionKind{
+	ReactionLike:    {entity: seqLikes, created: opLikeCreated, deleted: opLikeDeleted, countColumn: "like_count"},
+	ReactionRechirp: {entity: seqRechirps, created: opRechirpCreated, deleted: opRechirpDeleted, countColumn: "rechirp_count"},
+}
+
+func lookupReaction(kind string) (reactionKind, error) {
+	k, ok := reactionKinds[kind]
+	if !ok {
+		return reactionKind{}, fmt.Errorf("unknown reaction %q", kind)
+	}
+	return k, nil
+}
+
+// reactionKindOf returns the kind of reaction a log operation adds or removes
+func reactionKindOf(op string) reactionKind {
+	for _, k := range reactionKinds {
+		if op == k.created || op == k.deleted {
+			return k
+		}
+	}
+	return reactionKind{}
+}
+
+// count returns the chirp's counter for reactions of kind k
+func (k reactionKind) count(chirp *Chirp) *int {
+	if k.entity == seqLikes {
+		return &chirp.LikeCount
+	}
+	return &chirp.RechirpCount
+}
+
+// reactions returns the reactions of kind k in the dataset
+func (data DBStructure) reactions(k reactionKind) map[int]Reaction {
+	if k.entity == seqLikes {
+		return data.Likes
+	}
+	return data.Rechirps
+}
+
+// React records userID reacting to the chirp in the way kind names and
+// returns the chirp with its updated counts. Reacting twice is a no-op.
+// Users can't react to the chirps of someone they blocked or who blocked them.
+func (db *DB) React(kind string, userID, chirpID int) (Chirp, error) {
+	k, err := lookupReaction(kind)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	var chirp Chirp
+	err = db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpID)
+		if !ok || chirp.Deleted {
+			return ErrChirpNotFound
+		}
+		if tx.db.index.restrictions.blocked(userID, chirp.AuthorID) {
+			return ErrBlocked
+		}
+		if _, ok := tx.db.index.reactions(k).byChirp[chirpID][userID]; ok {
+			return nil
+		}
+
+		reaction := Reaction{
+			ID:        tx.nextID(k.entity),
+			UserID:    userID,
+			ChirpID:   chirpID,
+			CreatedAt: time.Now().UTC(),
+		}
+		if err := tx.log(logEntry{Op: k.created, Reaction: &reaction}); err != nil {
+			return err
+		}
+		*k.count(&chirp)++
+		return tx.log(logEntry{Op: opChirpUpdated, Chirp: &chirp})
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.view(chirp), nil
+}
+
+// Unreact removes userID's reaction of kind from the chirp and returns the
+// chirp with its updated counts. Removing a missing reaction is a no-op.
+func (db *DB) Unreact(kind string, userID, chirpID int) (Chirp, error) {
+	k, err := lookupReaction(kind)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	var chirp Chirp
+	err = db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(chirpID)
+		if !ok || chirp.Deleted {
+			return ErrChirpNotFound
+		}
+		id, ok := tx.db.index.reactions(k).byChirp[chirpID][userID]
+		if !ok {
+			return nil
+		}
+
+		if err := tx.log(logEntry{Op: k.deleted, ID: id}); err != nil {
+			return err
+		}
+		*k.count(&chirp)--
+		return tx.log(logEntry{Op: opChirpUpdated, Chirp: &chirp})
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.view(chirp), nil
+}
+
+// GetLikes returns a page of the chirps userID has liked, ordered by when
+// they were liked
+func (db *DB) GetLikes(userID int, page Page) ([]LikedChirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	ids := page.window(db.index.likes.byUser[userID])
+	chirps := make([]LikedChirp, 0, len(ids))
+	for _, id := range ids {
+		like := db.data.Likes[id]
+		chirps = append(chirps, LikedChirp{Chirp: db.view(db.data.Chirps[like.ChirpID]), LikeID: like.ID, LikedAt: like.CreatedAt})
+	}
+	return chirps, nil
+}
+
+// deleteReactions removes every reaction to the chirp, ahead of it being deleted
+func (tx *Tx) deleteReactions(chirpID int) error {
+	for _, k := range reactionKinds {
+		for _, id := range tx.db.index.reactions(k).byChirp[chirpID] {
+			if err := tx.log(logEntry{Op: k.deleted, ID: id}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
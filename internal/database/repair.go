@@ -0,0 +1,204 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// IDCollision describes a record whose id clashed with another record's
+type IDCollision struct {
+	Entity string
+	ID     int
+	Detail string
+}
+
+func (c IDCollision) String() string {
+	return fmt.Sprintf("%s %d: %s", c.Entity, c.ID, c.Detail)
+}
+
+// CheckIDs scans the JSON database at path, and the operation log next to
+// it, for ids that were handed out twice. Before ids came from persisted
+// sequences a new record took len(records)+1 as its id, so creating a record
+// after a delete silently replaced an existing one.
+//
+// Records that were overwritten before the last snapshot are gone, so only
+// the symptoms left in the snapshot (keys that don't match the record's id,
+// duplicate keys) can be reported for them. Overwrites still in the log are
+// reported individually.
+func CheckIDs(path string, opts Options) ([]IDCollision, error) {
+	env, err := openEnvelope(path, opts.MasterKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = env.decodeFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc jsonDocument
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	}
+
+	var collisions []IDCollision
+	ids := map[string]map[int]bool{}
+	for _, entity := range []string{seqChirps, seqUsers, seqTokens} {
+		ids[entity] = map[int]bool{}
+		raw, ok := doc[entity]
+		if !ok {
+			continue
+		}
+		found, err := checkSnapshotIDs(entity, raw, ids[entity])
+		if err != nil {
+			return nil, err
+		}
+		collisions = append(collisions, found...)
+	}
+
+	var snapshotSeq uint64
+	if raw, ok := doc["log_sequence"]; ok {
+		if err := json.Unmarshal(raw, &snapshotSeq); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, logPath := range []string{path + ".wal.old", path + ".wal"} {
+		found, err := checkLogIDs(logPath, env, snapshotSeq, ids)
+		if err != nil {
+			return nil, err
+		}
+		collisions = append(collisions, found...)
+	}
+
+	return collisions, nil
+}
+
+// checkSnapshotIDs walks one collection of the snapshot token by token, since
+// decoding it into a map would hide duplicate keys
+func checkSnapshotIDs(entity string, raw json.RawMessage, seen map[int]bool) ([]IDCollision, error) {
+	var collisions []IDCollision
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("%s is not an object", entity)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, err := strconv.Atoi(tok.(string))
+		if err != nil {
+			return nil, fmt.Errorf("%s has non-numeric key %q", entity, tok)
+		}
+
+		var record struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if err := dec.Decode(&record); err != nil {
+			return nil, err
+		}
+
+		if seen[key] {
+			collisions = append(collisions, IDCollision{Entity: entity, ID: key, Detail: "key appears more than once in the snapshot"})
+		}
+		seen[key] = true
+
+		// Revoked tokens use the token string as their id, only chirps
+		// and users carry the numeric id in the record
+		if entity == seqTokens {
+			continue
+		}
+		var id int
+		if err := json.Unmarshal(record.ID, &id); err != nil {
+			return nil, err
+		}
+		if id != key {
+			collisions = append(collisions, IDCollision{Entity: entity, ID: key, Detail: fmt.Sprintf("stored under key %d but record has id %d", key, id)})
+		}
+	}
+	return collisions, nil
+}
+
+// checkLogIDs replays the ids created and deleted in the log at path and
+// reports every create that replaced a live record
+func checkLogIDs(path string, env *envelope, snapshotSeq uint64, ids map[string]map[int]bool) ([]IDCollision, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var collisions []IDCollision
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var line logEntry
+		plain, err := env.decodeLine(scanner.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(plain, &line); err != nil {
+			// A torn final line is harmless, NewDB truncates it
+			continue
+		}
+		if line.Seq <= snapshotSeq {
+			continue
+		}
+
+		entries := []logEntry{line}
+		if line.Op == opBatch {
+			entries = line.Batch
+		}
+		for _, e := range entries {
+			if found, ok := checkLogEntryID(e, ids); ok {
+				found.Detail = fmt.Sprintf("log entry %d replaced an existing record", line.Seq)
+				collisions = append(collisions, found)
+			}
+		}
+	}
+	return collisions, scanner.Err()
+}
+
+// checkLogEntryID records the id created or deleted by e and reports whether
+// it replaced a live record
+func checkLogEntryID(e logEntry, ids map[string]map[int]bool) (IDCollision, bool) {
+	entity, id := "", 0
+	switch e.Op {
+	case opChirpCreated:
+		entity, id = seqChirps, e.Chirp.ID
+	case opUserCreated:
+		entity, id = seqUsers, e.User.ID
+	case opTokenRevoked:
+		entity, id = seqTokens, e.ID
+	case opChirpDeleted:
+		delete(ids[seqChirps], e.ID)
+		return IDCollision{}, false
+	case opUserDeleted:
+		delete(ids[seqUsers], e.ID)
+		return IDCollision{}, false
+	case opTokenDeleted:
+		delete(ids[seqTokens], e.ID)
+		return IDCollision{}, false
+	default:
+		return IDCollision{}, false
+	}
+
+	collided := ids[entity][id]
+	ids[entity][id] = true
+	return IDCollision{Entity: entity, ID: id}, collided
+}
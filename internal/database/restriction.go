@@ -0,0 +1,226 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of restriction a user can place on another
+const (
+	// RestrictionBlock stops the two users following, replying to or
+	// quoting each other, drops their mentions of each other, and hides
+	// each one's chirps from the other
+	RestrictionBlock = "block"
+	// RestrictionMute quietly leaves the target's chirps out of the user's
+	// timeline and search results
+	RestrictionMute = "mute"
+)
+
+var (
+	// ErrRestrictSelf is returned when a user tries to block or mute themselves
+	ErrRestrictSelf = errors.New("users can't block or mute themselves")
+	// ErrBlocked is returned when one of the users involved has blocked the other
+	ErrBlocked = errors.New("user is blocked")
+)
+
+// Restriction records a user blocking or muting another
+type Restriction struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TargetID  int       `json:"target_id"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RestrictedUser is a user in a list of blocked or muted users
+type RestrictedUser struct {
+	// RestrictionID is the id of the restriction, which orders the list
+	RestrictionID int       `json:"-"`
+	UserID        int       `json:"id"`
+	Handle        string    `json:"handle"`
+	RestrictedAt  time.Time `json:"restricted_at"`
+}
+
+// Restrictions are the users whose chirps are hidden from a user
+type Restrictions struct {
+	// Blocked and BlockedBy are the users they blocked and those who blocked them
+	Blocked   []int
+	BlockedBy []int
+	Muted     []int
+}
+
+func checkRestriction(kind string) error {
+	if kind != RestrictionBlock && kind != RestrictionMute {
+		return fmt.Errorf("unknown restriction %q", kind)
+	}
+	return nil
+}
+
+// restrictionKey identifies a restriction by what it does rather than its id
+type restrictionKey struct {
+	kind     string
+	userID   int
+	targetID int
+}
+
+// restrictionIndex indexes the restrictions in the dataset
+type restrictionIndex struct {
+	byKey map[restrictionKey]int
+	// byUser and byTarget hold the sorted ids of the restrictions placed by
+	// and on each user
+	byUser   map[int][]int
+	byTarget map[int][]int
+}
+
+func (idx *restrictionIndex) add(r Restriction) {
+	idx.byKey[restrictionKey{r.Kind, r.UserID, r.TargetID}] = r.ID
+	idx.byUser[r.UserID] = insertID(idx.byUser[r.UserID], r.ID)
+	idx.byTarget[r.TargetID] = insertID(idx.byTarget[r.TargetID], r.ID)
+}
+
+func (idx *restrictionIndex) remove(r Restriction) {
+	delete(idx.byKey, restrictionKey{r.Kind, r.UserID, r.TargetID})
+	if ids := removeID(idx.byUser[r.UserID], r.ID); len(ids) == 0 {
+		delete(idx.byUser, r.UserID)
+	} else {
+		idx.byUser[r.UserID] = ids
+	}
+	if ids := removeID(idx.byTarget[r.TargetID], r.ID); len(ids) == 0 {
+		delete(idx.byTarget, r.TargetID)
+	} else {
+		idx.byTarget[r.TargetID] = ids
+	}
+}
+
+// blocked reports whether either user has blocked the other
+func (idx *restrictionIndex) blocked(a, b int) bool {
+	_, ab := idx.byKey[restrictionKey{RestrictionBlock, a, b}]
+	_, ba := idx.byKey[restrictionKey{RestrictionBlock, b, a}]
+	return ab || ba
+}
+
+// dropBlockedMentions leaves out the mentions of users who have blocked the
+// author or whom the author blocked, so they stay plain text and the chirp
+// doesn't reveal the block by failing
+func dropBlockedMentions(mentions []Mention, blocked func(userID int) (bool, error)) ([]Mention, error) {
+	var kept []Mention
+	for _, m := range mentions {
+		ok, err := blocked(m.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			kept = append(kept, m)
+		}
+	}
+	return kept, nil
+}
+
+// Restrict makes userID block or mute targetID, as kind says. Restricting
+// twice is a no-op. Blocking also ends any follows between the two.
+func (db *DB) Restrict(kind string, userID, targetID int) error {
+	if err := checkRestriction(kind); err != nil {
+		return err
+	}
+	if userID == targetID {
+		return ErrRestrictSelf
+	}
+	return db.Update(func(tx *Tx) error {
+		if _, ok := tx.User(targetID); !ok {
+			return ErrUserNotFound
+		}
+		if _, ok := tx.db.index.restrictions.byKey[restrictionKey{kind, userID, targetID}]; ok {
+			return nil
+		}
+
+		restriction := Restriction{
+			ID:        tx.nextID(seqRestrictions),
+			UserID:    userID,
+			TargetID:  targetID,
+			Kind:      kind,
+			CreatedAt: time.Now().UTC(),
+		}
+		if err := tx.log(logEntry{Op: opRestrictionCreated, Restriction: &restriction}); err != nil {
+			return err
+		}
+		if kind != RestrictionBlock {
+			return nil
+		}
+		for _, pair := range [][2]int{{userID, targetID}, {targetID, userID}} {
+			if id, ok := tx.db.index.follows.byPair[pair[0]][pair[1]]; ok {
+				if err := tx.log(logEntry{Op: opFollowDeleted, ID: id}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Unrestrict lifts userID's block or mute of targetID. Lifting a missing
+// restriction is a no-op; follows ended by a block are not restored.
+func (db *DB) Unrestrict(kind string, userID, targetID int) error {
+	if err := checkRestriction(kind); err != nil {
+		return err
+	}
+	return db.Update(func(tx *Tx) error {
+		if _, ok := tx.User(targetID); !ok {
+			return ErrUserNotFound
+		}
+		id, ok := tx.db.index.restrictions.byKey[restrictionKey{kind, userID, targetID}]
+		if !ok {
+			return nil
+		}
+		return tx.log(logEntry{Op: opRestrictionDeleted, ID: id})
+	})
+}
+
+// GetRestricted returns a page of the users userID has blocked or muted, as
+// kind says, ordered by when they were
+func (db *DB) GetRestricted(kind string, userID int, page Page) ([]RestrictedUser, error) {
+	if err := checkRestriction(kind); err != nil {
+		return nil, err
+	}
+
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var ids []int
+	for _, id := range db.index.restrictions.byUser[userID] {
+		if db.data.Restrictions[id].Kind == kind {
+			ids = append(ids, id)
+		}
+	}
+
+	ids = page.window(ids)
+	users := make([]RestrictedUser, 0, len(ids))
+	for _, id := range ids {
+		r := db.data.Restrictions[id]
+		users = append(users, RestrictedUser{RestrictionID: id, UserID: r.TargetID, Handle: db.data.Users[r.TargetID].Handle, RestrictedAt: r.CreatedAt})
+	}
+	return users, nil
+}
+
+// GetRestrictions returns the users userID has blocked or muted and those
+// who have blocked them
+func (db *DB) GetRestrictions(userID int) (Restrictions, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var restrictions Restrictions
+	for _, id := range db.index.restrictions.byUser[userID] {
+		r := db.data.Restrictions[id]
+		if r.Kind == RestrictionBlock {
+			restrictions.Blocked = append(restrictions.Blocked, r.TargetID)
+		} else {
+			restrictions.Muted = append(restrictions.Muted, r.TargetID)
+		}
+	}
+	for _, id := range db.index.restrictions.byTarget[userID] {
+		if r := db.data.Restrictions[id]; r.Kind == RestrictionBlock {
+			restrictions.BlockedBy = append(restrictions.BlockedBy, r.UserID)
+		}
+	}
+	return restrictions, nil
+}
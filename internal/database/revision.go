@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotChirpAuthor is returned when someone other than its author edits
+	// or deletes a chirp
+	ErrNotChirpAuthor = errors.New("only the author can edit or delete a chirp")
+	// ErrEditWindowClosed is returned when a chirp is edited after its edit window
+	ErrEditWindowClosed = errors.New("the time to edit this chirp has passed")
+)
+
+// Revision is a body a chirp had before it was edited
+type Revision struct {
+	ID      int    `json:"id"`
+	ChirpID int    `json:"chirp_id"`
+	Body    string `json:"body"`
+	// CreatedAt is when the body was written and ReplacedAt when it was edited away
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// lastWritten returns when the chirp's current body was written
+func (chirp Chirp) lastWritten() time.Time {
+	if chirp.EditedAt != nil {
+		return *chirp.EditedAt
+	}
+	return chirp.CreatedAt
+}
+
+// EditChirp replaces the body of a chirp owned by authorID, keeping the old
+// body as a revision. Tags and mentions are extracted again from the new
+// body. A window above 0 limits how long after creation a chirp can be edited.
+func (db *DB) EditChirp(authorID, id int, body string, window time.Duration) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(id)
+		if !ok || chirp.Deleted {
+			return ErrChirpNotFound
+		}
+		if chirp.AuthorID != authorID {
+			return ErrNotChirpAuthor
+		}
+		now := time.Now().UTC()
+		if window > 0 && now.Sub(chirp.CreatedAt) > window {
+			return ErrEditWindowClosed
+		}
+		if body == chirp.Body {
+			return nil
+		}
+
+		mentions, err := tx.mentions(authorID, body)
+		if err != nil {
+			return err
+		}
+
+		revision := Revision{
+			ID:         tx.nextID(seqRevisions),
+			ChirpID:    id,
+			Body:       chirp.Body,
+			CreatedAt:  chirp.lastWritten(),
+			ReplacedAt: now,
+		}
+		if err := tx.log(logEntry{Op: opRevisionCreated, Revision: &revision}); err != nil {
+			return err
+		}
+
+		chirp.Body = body
+		chirp.Tags = extractTags(body)
+		chirp.Mentions = mentions
+		chirp.EditedAt = &now
+		return tx.log(logEntry{Op: opChirpUpdated, Chirp: &chirp})
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.view(chirp), nil
+}
+
+// GetRevisions returns a page of the earlier bodies of a chirp, oldest first
+func (db *DB) GetRevisions(chirpID int, page Page) ([]Revision, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	if chirp, ok := db.data.Chirps[chirpID]; !ok || chirp.Deleted {
+		return nil, ErrChirpNotFound
+	}
+
+	ids := page.window(db.index.revisions[chirpID])
+	revisions := make([]Revision, 0, len(ids))
+	for _, id := range ids {
+		revisions = append(revisions, db.data.Revisions[id])
+	}
+	return revisions, nil
+}
+
+// deleteRevisions removes the revisions of a chirp, ahead of it being deleted
+func (tx *Tx) deleteRevisions(chirpID int) error {
+	for _, id := range append([]int(nil), tx.db.index.revisions[chirpID]...) {
+		if err := tx.log(logEntry{Op: opRevisionDeleted, ID: id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
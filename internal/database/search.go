@@ -0,0 +1,233 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrEmptySearch is returned when a search query has no words to look for
+var ErrEmptySearch = errors.New("search query has no words")
+
+// recencyHalfLife is how long it takes a chirp's recency boost to halve. A new
+// chirp ranks twice as high as an equally relevant old one.
+const recencyHalfLife = 7 * 24 * time.Hour
+
+// SearchQuery describes a chirp search
+type SearchQuery struct {
+	// Text holds the words to find. Every word must match; "quoted words"
+	// must appear together in that order.
+	Text string
+	// AuthorID, if set, only matches chirps by that author
+	AuthorID int
+	// ExcludeAuthors are authors whose chirps never match
+	ExcludeAuthors []int
+	// Since and Until, if set, only match chirps created in [Since, Until)
+	Since time.Time
+	Until time.Time
+	// Offset and Limit select a window of the ranked results, Limit 0 for no limit
+	Offset int
+	Limit  int
+}
+
+// searchHit is a matching chirp and how relevant it is to the query
+type searchHit struct {
+	chirp     Chirp
+	relevance float64
+}
+
+// tokenize splits text into lowercase words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// parsePhrases splits a query into phrases: each "quoted" part is one phrase,
+// and every other word is a phrase of its own
+func parsePhrases(text string) [][]string {
+	var phrases [][]string
+	for i, part := range strings.Split(text, `"`) {
+		words := tokenize(part)
+		if i%2 == 1 {
+			if len(words) > 0 {
+				phrases = append(phrases, words)
+			}
+			continue
+		}
+		for _, word := range words {
+			phrases = append(phrases, []string{word})
+		}
+	}
+	return phrases
+}
+
+// matches reports whether the chirp passes the query's author and date filters
+func (q SearchQuery) matches(chirp Chirp) bool {
+	if q.AuthorID != 0 && chirp.AuthorID != q.AuthorID {
+		return false
+	}
+	for _, id := range q.ExcludeAuthors {
+		if chirp.AuthorID == id {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && chirp.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !chirp.CreatedAt.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// rank orders hits by relevance weighted by recency, newest first on ties,
+// and returns the window of chirps selected by the query
+func (q SearchQuery) rank(hits []searchHit, now time.Time) []Chirp {
+	scores := make([]float64, len(hits))
+	for i, hit := range hits {
+		age := now.Sub(hit.chirp.CreatedAt)
+		if age < 0 {
+			age = 0
+		}
+		scores[i] = hit.relevance * (1 + math.Exp2(-float64(age)/float64(recencyHalfLife)))
+	}
+	sort.Sort(byScore{hits, scores})
+
+	if q.Offset >= len(hits) {
+		return []Chirp{}
+	}
+	hits = hits[q.Offset:]
+	if q.Limit != 0 && q.Limit < len(hits) {
+		hits = hits[:q.Limit]
+	}
+
+	chirps := make([]Chirp, len(hits))
+	for i, hit := range hits {
+		chirps[i] = hit.chirp
+	}
+	return chirps
+}
+
+type byScore struct {
+	hits   []searchHit
+	scores []float64
+}
+
+func (s byScore) Len() int { return len(s.hits) }
+
+func (s byScore) Less(i, j int) bool {
+	if s.scores[i] != s.scores[j] {
+		return s.scores[i] > s.scores[j]
+	}
+	return s.hits[i].chirp.ID > s.hits[j].chirp.ID
+}
+
+func (s byScore) Swap(i, j int) {
+	s.hits[i], s.hits[j] = s.hits[j], s.hits[i]
+	s.scores[i], s.scores[j] = s.scores[j], s.scores[i]
+}
+
+// postings maps each chirp containing a word to the word's positions in it
+type postings map[int][]int
+
+// addTerms indexes the words of the chirp's body
+func (idx *dbIndex) addTerms(chirp Chirp) {
+	for pos, word := range tokenize(chirp.Body) {
+		p, ok := idx.terms[word]
+		if !ok {
+			p = postings{}
+			idx.terms[word] = p
+		}
+		p[chirp.ID] = append(p[chirp.ID], pos)
+	}
+}
+
+// removeTerms drops the chirp from the postings of every word in its body
+func (idx *dbIndex) removeTerms(chirp Chirp) {
+	for _, word := range tokenize(chirp.Body) {
+		p := idx.terms[word]
+		delete(p, chirp.ID)
+		if len(p) == 0 {
+			delete(idx.terms, word)
+		}
+	}
+}
+
+// SearchChirps returns the chirps matching q, most relevant first. Matching
+// uses the inverted index; relevance is tf-idf over the query's words.
+func (db *DB) SearchChirps(q SearchQuery) ([]Chirp, error) {
+	phrases := parsePhrases(q.Text)
+	if len(phrases) == 0 {
+		return nil, ErrEmptySearch
+	}
+
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	// Walk the postings of the rarest word, the smallest candidate set
+	var rarest postings
+	for _, phrase := range phrases {
+		for _, word := range phrase {
+			p := db.index.terms[word]
+			if len(p) == 0 {
+				return []Chirp{}, nil
+			}
+			if rarest == nil || len(p) < len(rarest) {
+				rarest = p
+			}
+		}
+	}
+
+	total := float64(len(db.data.Chirps))
+	var hits []searchHit
+	for id := range rarest {
+		chirp := db.data.Chirps[id]
+		if !q.matches(chirp) || !db.index.containsPhrases(id, phrases) {
+			continue
+		}
+
+		var relevance float64
+		for _, phrase := range phrases {
+			for _, word := range phrase {
+				p := db.index.terms[word]
+				idf := math.Log(1 + total/float64(len(p)))
+				relevance += (1 + math.Log(float64(len(p[id])))) * idf
+			}
+		}
+		hits = append(hits, searchHit{chirp: db.view(chirp), relevance: relevance})
+	}
+
+	return q.rank(hits, time.Now().UTC()), nil
+}
+
+// containsPhrases reports whether every phrase appears in the chirp, its
+// words at consecutive positions
+func (idx *dbIndex) containsPhrases(id int, phrases [][]string) bool {
+	for _, phrase := range phrases {
+		if !idx.containsPhrase(id, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *dbIndex) containsPhrase(id int, phrase []string) bool {
+	for _, start := range idx.terms[phrase[0]][id] {
+		found := true
+		for i, word := range phrase[1:] {
+			positions := idx.terms[word][id]
+			if j := sort.SearchInts(positions, start+i+1); j == len(positions) || positions[j] != start+i+1 {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,577 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDB is a Store backed by a SQLite database file
+type SQLiteDB struct {
+	db *sql.DB
+
+	// snowflake generates ids when configured, otherwise SQLite assigns them.
+	// AUTOINCREMENT keys are never reused, even after deletes.
+	snowflake *snowflake
+}
+
+// NewSQLiteDB opens the SQLite database at path and upgrades it to the latest schema version
+func NewSQLiteDB(path string, opts Options) (*SQLiteDB, error) {
+	s, err := openSQLiteDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.snowflake, err = newIDGenerator(opts)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if err := s.MigrateUp(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if s.snowflake != nil {
+		if err := s.resumeSnowflake(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// openSQLiteDB opens the SQLite database at path without running any migrations
+func openSQLiteDB(path string) (*SQLiteDB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer at a time, so serialise access through a
+	// single connection instead of retrying on SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteDB{db: db}, nil
+}
+
+// newID returns the id to insert for a new row, nil lets SQLite assign it
+func (s *SQLiteDB) newID() interface{} {
+	if s.snowflake == nil {
+		return nil
+	}
+	return s.snowflake.next()
+}
+
+// snowflakeTables are the tables whose ids come from newID
+var snowflakeTables = []string{"chirps", "users", "revoked_tokens", "likes", "rechirps", "chirp_revisions", "drafts", "media", "follows", "restrictions"}
+
+// resumeSnowflake makes the snowflake generator hand out ids above those
+// already stored
+func (s *SQLiteDB) resumeSnowflake() error {
+	for _, table := range snowflakeTables {
+		var id sql.NullInt64
+		if err := s.db.QueryRow(`SELECT MAX(id) FROM ` + table).Scan(&id); err != nil {
+			return err
+		}
+		s.snowflake.resume(int(id.Int64))
+	}
+	return nil
+}
+
+// Close closes the underlying database
+func (s *SQLiteDB) Close() error {
+	return s.db.Close()
+}
+
+// chirpColumns are the columns scanChirp reads, qualified so they can be
+// selected from joins. The quoted chirp's columns are looked up by subquery
+// and come back NULL once it is deleted.
+const chirpColumns = `chirps.id, chirps.author_id, chirps.body, chirps.created_at, chirps.tags, chirps.mentions, chirps.in_reply_to, chirps.deleted, chirps.like_count, chirps.rechirp_count, chirps.edited_at, chirps.deleted_at, chirps.media, chirps.quoted_chirp_id, ` +
+	`(SELECT quoted.author_id FROM chirps AS quoted WHERE quoted.id = chirps.quoted_chirp_id AND NOT quoted.deleted), ` +
+	`(SELECT quoted.body FROM chirps AS quoted WHERE quoted.id = chirps.quoted_chirp_id AND NOT quoted.deleted), ` +
+	`(SELECT quoted.created_at FROM chirps AS quoted WHERE quoted.id = chirps.quoted_chirp_id AND NOT quoted.deleted)`
+
+// CreateChirp creates a new chirp
+func (s *SQLiteDB) CreateChirp(c NewChirp) (Chirp, error) {
+	var chirp Chirp
+	err := s.withTx(func(tx *sql.Tx) error {
+		var err error
+		chirp, err = s.createChirp(tx, c)
+		return err
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// checkReferences returns an error unless the chirps c replies to and
+// quotes, if any, exist, aren't deleted and aren't by a user who has blocked
+// or been blocked by its author, and its author uploaded its media. It
+// returns the media as attachments.
+func checkReferences(tx *sql.Tx, c NewChirp) ([]Attachment, error) {
+	if c.InReplyTo != 0 {
+		if err := checkChirp(tx, c.InReplyTo); errors.Is(err, ErrChirpNotFound) {
+			return nil, ErrParentNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		if err := checkChirpBlocked(tx, c.AuthorID, c.InReplyTo); err != nil {
+			return nil, err
+		}
+	}
+	if c.QuotedChirpID != 0 {
+		if err := checkChirp(tx, c.QuotedChirpID); errors.Is(err, ErrChirpNotFound) {
+			return nil, ErrQuotedNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		if err := checkChirpBlocked(tx, c.AuthorID, c.QuotedChirpID); err != nil {
+			return nil, err
+		}
+	}
+	return sqlAttachments(tx, c.AuthorID, c.MediaIDs)
+}
+
+// createChirp creates a new chirp in the transaction
+func (s *SQLiteDB) createChirp(tx *sql.Tx, c NewChirp) (Chirp, error) {
+	attachments, err := checkReferences(tx, c)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp := Chirp{
+		AuthorID:      c.AuthorID,
+		Body:          c.Body,
+		CreatedAt:     time.Now().UTC(),
+		Tags:          extractTags(c.Body),
+		InReplyTo:     c.InReplyTo,
+		QuotedChirpID: c.QuotedChirpID,
+	}
+	var inReplyTo, quotedID interface{}
+	if c.InReplyTo != 0 {
+		inReplyTo = c.InReplyTo
+	}
+	if c.QuotedChirpID != 0 {
+		quotedID = c.QuotedChirpID
+	}
+
+	if chirp.Mentions, err = resolveSQLMentions(tx, c.AuthorID, c.Body); err != nil {
+		return Chirp{}, err
+	}
+	mentions, err := encodeMentions(chirp.Mentions)
+	if err != nil {
+		return Chirp{}, err
+	}
+	media, err := encodeAttachments(attachments)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	res, err := tx.Exec(`INSERT INTO chirps (id, author_id, body, created_at, tags, mentions, media, in_reply_to, quoted_chirp_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.newID(), c.AuthorID, c.Body, chirp.CreatedAt, strings.Join(chirp.Tags, " "), mentions, media, inReplyTo, quotedID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.ID = int(id)
+
+	if err := insertChirpTags(tx, chirp); err != nil {
+		return Chirp{}, err
+	}
+	if err := insertChirpMentions(tx, chirp); err != nil {
+		return Chirp{}, err
+	}
+
+	// Read the chirp back for its quoted chirp
+	return scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirp.ID))
+}
+
+// GetChirps returns a page of the chirps in the database, ordered by id
+func (s *SQLiteDB) GetChirps(page Page) ([]Chirp, error) {
+	clause, args := page.sql("chirps.id", "NOT deleted")
+	return s.queryChirps(`SELECT `+chirpColumns+` FROM chirps`+clause, args...)
+}
+
+// GetChirp returns the chirp with the given id
+func (s *SQLiteDB) GetChirp(id int) (Chirp, error) {
+	chirp, err := scanChirp(s.db.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrChirpNotFound
+	}
+	return chirp, err
+}
+
+// GetChirpsByAuthor returns a page of the author's chirps, ordered by id
+func (s *SQLiteDB) GetChirpsByAuthor(authorID int, page Page) ([]Chirp, error) {
+	clause, args := page.sql("chirps.id", "author_id = ? AND NOT deleted", authorID)
+	return s.queryChirps(`SELECT `+chirpColumns+` FROM chirps`+clause, args...)
+}
+
+func (s *SQLiteDB) queryChirps(query string, args ...interface{}) ([]Chirp, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanChirps(rows)
+}
+
+// scanChirps scans and closes rows of chirpColumns
+func scanChirps(rows *sql.Rows) ([]Chirp, error) {
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+// rowQuerier is a *sql.DB or *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanChirp scans chirpColumns, followed by any extra columns into extra,
+// into the chirp as it is served: deleted chirps become tombstones
+func scanChirp(row rowScanner, extra ...interface{}) (Chirp, error) {
+	chirp, err := scanStoredChirp(row, extra...)
+	if chirp.Deleted {
+		chirp = chirp.tombstone()
+	}
+	return chirp, err
+}
+
+// scanStoredChirp scans chirpColumns, followed by any extra columns into
+// extra, into the chirp as it is stored
+func scanStoredChirp(row rowScanner, extra ...interface{}) (Chirp, error) {
+	var chirp Chirp
+	var tags, mentions, media string
+	var inReplyTo, quotedID, quotedAuthorID sql.NullInt64
+	var quotedBody sql.NullString
+	var editedAt, deletedAt, quotedCreatedAt sql.NullTime
+	dest := append([]interface{}{&chirp.ID, &chirp.AuthorID, &chirp.Body, &chirp.CreatedAt, &tags, &mentions, &inReplyTo, &chirp.Deleted, &chirp.LikeCount, &chirp.RechirpCount, &editedAt, &deletedAt, &media,
+		&quotedID, &quotedAuthorID, &quotedBody, &quotedCreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return Chirp{}, err
+	}
+	chirp.InReplyTo = int(inReplyTo.Int64)
+	if editedAt.Valid {
+		chirp.EditedAt = &editedAt.Time
+	}
+	if deletedAt.Valid {
+		chirp.DeletedAt = &deletedAt.Time
+	}
+	if chirp.QuotedChirpID = int(quotedID.Int64); quotedID.Valid {
+		quoted := Chirp{ID: chirp.QuotedChirpID, AuthorID: int(quotedAuthorID.Int64), Body: quotedBody.String, CreatedAt: quotedCreatedAt.Time}
+		chirp.Quoted = quote(chirp.QuotedChirpID, quoted, quotedAuthorID.Valid)
+	}
+	chirp.Tags = strings.Fields(tags)
+	if len(chirp.Tags) == 0 {
+		chirp.Tags = nil
+	}
+	if mentions != "" {
+		if err := json.Unmarshal([]byte(mentions), &chirp.Mentions); err != nil {
+			return Chirp{}, err
+		}
+	}
+	if media != "" {
+		if err := json.Unmarshal([]byte(media), &chirp.Media); err != nil {
+			return Chirp{}, err
+		}
+	}
+	return chirp, nil
+}
+
+// DeleteChirp moves a chirp owned by authorID to the trash, where it is
+// hidden but can be restored until it is purged (see sqlite_trash.go)
+func (s *SQLiteDB) DeleteChirp(authorID, id int) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var owner int
+		var deleted bool
+		err := tx.QueryRow(`SELECT author_id, deleted FROM chirps WHERE id = ?`, id).Scan(&owner, &deleted)
+		if errors.Is(err, sql.ErrNoRows) || deleted {
+			return ErrChirpNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if owner != authorID {
+			return ErrNotChirpAuthor
+		}
+
+		// Tags and mentions are recorded again from the chirp if it is restored
+		if _, err := tx.Exec(`UPDATE chirps SET deleted = 1, deleted_at = ? WHERE id = ?`, time.Now().UTC(), id); err != nil {
+			return err
+		}
+		for _, stmt := range []string{
+			`DELETE FROM chirp_tags WHERE chirp_id = ?`,
+			`DELETE FROM chirp_mentions WHERE chirp_id = ?`,
+		} {
+			if _, err := tx.Exec(stmt, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// userColumns are the columns scanUser reads
+const userColumns = `id, email, password, is_chirpy_red, handle, display_name, bio, avatar_id, created_at, email_verified, verification_id, verification_sent_at, reset_token_hash, reset_sent_at, tokens_valid_after`
+
+// CreateUser creates a new user with a bcrypt hashed password, whose email
+// starts out unverified. An empty handle is derived from the email.
+func (s *SQLiteDB) CreateUser(email, password, handle string) (User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return User{}, err
+	}
+	if handle != "" {
+		var err error
+		if handle, err = NormalizeHandle(handle); err != nil {
+			return User{}, err
+		}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{
+		Email:      email,
+		Password:   string(hashedPassword),
+		Membership: false,
+		Handle:     handle,
+		CreatedAt:  time.Now().UTC(),
+	}
+	err = s.withTx(func(tx *sql.Tx) error {
+		if err := checkEmail(tx, email, 0); err != nil {
+			return err
+		}
+		if user.Handle == "" {
+			var lookupErr error
+			user.Handle = handleFromEmail(email, func(h string) bool {
+				var n int
+				if err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE handle = ?`, h).Scan(&n); err != nil {
+					lookupErr = err
+					return false
+				}
+				return n > 0
+			})
+			if lookupErr != nil {
+				return lookupErr
+			}
+		}
+
+		res, err := tx.Exec(`INSERT INTO users (id, email, password, handle, created_at) VALUES (?, ?, ?, ?, ?)`,
+			s.newID(), email, user.Password, user.Handle, user.CreatedAt)
+		if err != nil {
+			return userConflict(err, email, user.Handle)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		user.ID = int(id)
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// GetUser returns the user with the given id
+func (s *SQLiteDB) GetUser(userID int) (User, error) {
+	row := s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, userID)
+	return scanUser(row)
+}
+
+// GetUserbyEmail returns the user with the given email, ignoring case and
+// surrounding spaces
+func (s *SQLiteDB) GetUserbyEmail(email string) (User, error) {
+	row := s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE LOWER(email) = ?`, emailKey(email))
+	return scanUser(row)
+}
+
+// checkEmail returns an error if a user other than userID has the email,
+// which must be normalized, ignoring case
+func checkEmail(q rowQuerier, email string, userID int) error {
+	var taken bool
+	err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = ? AND id != ?)`, email, userID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("user with email %s already exists", email)
+	}
+	return nil
+}
+
+// GetUserByHandle returns the user with the given handle
+func (s *SQLiteDB) GetUserByHandle(handle string) (User, error) {
+	row := s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE handle = ?`, handle)
+	return scanUser(row)
+}
+
+// UpdateUser sets a new email and password for the user, and a new handle
+// unless handle is empty. A changed email has to be verified again.
+func (s *SQLiteDB) UpdateUser(userID int, email, password, handle string) (User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return User{}, err
+	}
+	if handle != "" {
+		var err error
+		if handle, err = NormalizeHandle(handle); err != nil {
+			return User{}, err
+		}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	err = s.withTx(func(tx *sql.Tx) error {
+		if err := checkEmail(tx, email, userID); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`
+UPDATE users SET email = ?, password = ?, handle = COALESCE(NULLIF(?, ''), handle),
+	email_verified = email_verified AND LOWER(email) = ?,
+	verification_id = CASE WHEN LOWER(email) = ? THEN verification_id ELSE '' END,
+	reset_token_hash = CASE WHEN LOWER(email) = ? THEN reset_token_hash ELSE '' END
+WHERE id = ?`,
+			email, string(hashedPassword), handle, email, email, email, userID)
+		if err != nil {
+			return userConflict(err, email, handle)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return s.GetUser(userID)
+}
+
+// UpdateMembership sets the Chirpy Red membership flag for the user
+func (s *SQLiteDB) UpdateMembership(userID int, membership bool) (User, error) {
+	res, err := s.db.Exec(`UPDATE users SET is_chirpy_red = ? WHERE id = ?`, membership, userID)
+	if err != nil {
+		return User{}, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return User{}, ErrUserNotFound
+	}
+
+	return s.GetUser(userID)
+}
+
+// RevokeToken records a revoked refresh token
+func (s *SQLiteDB) RevokeToken(tokenID string, revokedAt time.Time) (RevokedToken, error) {
+	if _, err := s.db.Exec(`INSERT INTO revoked_tokens (id, token, revoked_at) VALUES (?, ?, ?)`, s.newID(), tokenID, revokedAt); err != nil {
+		return RevokedToken{}, err
+	}
+
+	return RevokedToken{
+		ID:        tokenID,
+		RevokedAt: revokedAt,
+	}, nil
+}
+
+// withTx runs fn in a transaction, committing if it succeeds and rolling back if it fails
+func (s *SQLiteDB) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkChirp returns ErrChirpNotFound unless the chirp exists and isn't a tombstone
+func checkChirp(tx *sql.Tx, chirpID int) error {
+	var deleted bool
+	err := tx.QueryRow(`SELECT deleted FROM chirps WHERE id = ?`, chirpID).Scan(&deleted)
+	if errors.Is(err, sql.ErrNoRows) || deleted {
+		return ErrChirpNotFound
+	}
+	return err
+}
+
+// scanUser reads a row of userColumns, followed by any extra columns into extra
+func scanUser(row rowScanner, extra ...interface{}) (User, error) {
+	var user User
+	var sentAt, resetSentAt, validAfter sql.NullTime
+	dest := []interface{}{&user.ID, &user.Email, &user.Password, &user.Membership, &user.Handle, &user.DisplayName, &user.Bio, &user.AvatarID, &user.CreatedAt,
+		&user.EmailVerified, &user.VerificationID, &sentAt, &user.ResetTokenHash, &resetSentAt, &validAfter}
+	err := row.Scan(append(dest, extra...)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if sentAt.Valid {
+		user.VerificationSentAt = &sentAt.Time
+	}
+	if resetSentAt.Valid {
+		user.ResetSentAt = &resetSentAt.Time
+	}
+	if validAfter.Valid {
+		user.TokensValidAfter = &validAfter.Time
+	}
+	return user, nil
+}
+
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// userConflict turns a unique violation on users into the error the JSON store returns
+func userConflict(err error, email, handle string) error {
+	switch {
+	case !isUniqueViolation(err):
+		return err
+	case strings.Contains(err.Error(), "users.handle"):
+		return fmt.Errorf("user with handle %s already exists", handle)
+	default:
+		return fmt.Errorf("user with email %s already exists", email)
+	}
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const draftColumns = `id, author_id, body, in_reply_to, quoted_chirp_id, media_ids, created_at, publish_at, publish_error`
+
+// scanDraft scans draftColumns into a draft
+func scanDraft(row rowScanner) (Draft, error) {
+	var draft Draft
+	var inReplyTo, quotedID sql.NullInt64
+	var mediaIDs string
+	var publishAt sql.NullTime
+	if err := row.Scan(&draft.ID, &draft.AuthorID, &draft.Body, &inReplyTo, &quotedID, &mediaIDs, &draft.CreatedAt, &publishAt, &draft.PublishError); err != nil {
+		return Draft{}, err
+	}
+	draft.InReplyTo = int(inReplyTo.Int64)
+	draft.QuotedChirpID = int(quotedID.Int64)
+	var err error
+	if draft.MediaIDs, err = decodeIDs(mediaIDs); err != nil {
+		return Draft{}, err
+	}
+	if publishAt.Valid {
+		draft.PublishAt = &publishAt.Time
+	}
+	return draft, nil
+}
+
+// queryDrafts runs a query selecting draftColumns
+func (s *SQLiteDB) queryDrafts(query string, args ...interface{}) ([]Draft, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []Draft{}
+	for rows.Next() {
+		draft, err := scanDraft(rows)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
+	}
+	return drafts, rows.Err()
+}
+
+// CreateDraft saves c as a draft, scheduled to be published at publishAt
+// unless it is nil
+func (s *SQLiteDB) CreateDraft(c NewChirp, publishAt *time.Time) (Draft, error) {
+	draft := Draft{
+		AuthorID:      c.AuthorID,
+		Body:          c.Body,
+		InReplyTo:     c.InReplyTo,
+		QuotedChirpID: c.QuotedChirpID,
+		MediaIDs:      c.MediaIDs,
+		CreatedAt:     time.Now().UTC(),
+		PublishAt:     publishAt,
+	}
+	var inReplyTo, quotedID interface{}
+	if c.InReplyTo != 0 {
+		inReplyTo = c.InReplyTo
+	}
+	if c.QuotedChirpID != 0 {
+		quotedID = c.QuotedChirpID
+	}
+
+	err := s.withTx(func(tx *sql.Tx) error {
+		if _, err := checkReferences(tx, c); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`INSERT INTO drafts (id, author_id, body, in_reply_to, quoted_chirp_id, media_ids, created_at, publish_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			s.newID(), c.AuthorID, c.Body, inReplyTo, quotedID, encodeIDs(c.MediaIDs), draft.CreatedAt, publishAt)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		draft.ID = int(id)
+		return err
+	})
+	if err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// GetDrafts returns a page of the author's scheduled or unscheduled drafts, ordered by id
+func (s *SQLiteDB) GetDrafts(authorID int, scheduled bool, page Page) ([]Draft, error) {
+	where := "author_id = ? AND publish_at IS NULL"
+	if scheduled {
+		where = "author_id = ? AND publish_at IS NOT NULL"
+	}
+	clause, args := page.sql("id", where, authorID)
+	return s.queryDrafts(`SELECT `+draftColumns+` FROM drafts`+clause, args...)
+}
+
+// DeleteDraft discards a draft, or cancels a scheduled one, owned by authorID
+func (s *SQLiteDB) DeleteDraft(authorID, id int) error {
+	res, err := s.db.Exec(`DELETE FROM drafts WHERE id = ? AND author_id = ?`, id, authorID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDraftNotFound
+	}
+	return nil
+}
+
+// DueDrafts returns the drafts scheduled for now or earlier, earliest first
+func (s *SQLiteDB) DueDrafts(now time.Time) ([]Draft, error) {
+	return s.queryDrafts(`SELECT `+draftColumns+` FROM drafts WHERE publish_at <= ? ORDER BY publish_at, id`, now.UTC())
+}
+
+// PublishDraft publishes a draft owned by authorID as a chirp and deletes
+// it, both in one transaction. If the chirp it replies to or quotes has been
+// deleted since, the draft is kept, unscheduled and with its PublishError
+// set, and the error is returned.
+func (s *SQLiteDB) PublishDraft(authorID, id int) (Chirp, error) {
+	var chirp Chirp
+	var publishErr error
+	err := s.withTx(func(tx *sql.Tx) error {
+		draft, err := scanDraft(tx.QueryRow(`SELECT `+draftColumns+` FROM drafts WHERE id = ?`, id))
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && draft.AuthorID != authorID) {
+			return ErrDraftNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		chirp, err = s.createChirp(tx, draft.chirp())
+		if publishFailed(err) {
+			publishErr = err
+			_, err = tx.Exec(`UPDATE drafts SET publish_at = NULL, publish_error = ? WHERE id = ?`, err.Error(), id)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM drafts WHERE id = ?`, id)
+		return err
+	})
+	if err == nil {
+		err = publishErr
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
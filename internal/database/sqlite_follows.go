@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// checkUser returns ErrUserNotFound unless the user exists
+func checkUser(q rowQuerier, userID int) error {
+	var id int
+	err := q.QueryRow(`SELECT id FROM users WHERE id = ?`, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// Follow makes followerID follow followeeID. Following twice is a no-op.
+func (s *SQLiteDB) Follow(followerID, followeeID int) error {
+	if followerID == followeeID {
+		return ErrFollowSelf
+	}
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := checkUser(tx, followeeID); err != nil {
+			return err
+		}
+		if blocked, err := sqlBlocked(tx, followerID, followeeID); err != nil {
+			return err
+		} else if blocked {
+			return ErrBlocked
+		}
+		_, err := tx.Exec(`INSERT OR IGNORE INTO follows (id, follower_id, followee_id, created_at) VALUES (?, ?, ?, ?)`,
+			s.newID(), followerID, followeeID, time.Now().UTC())
+		return err
+	})
+}
+
+// Unfollow stops followerID following followeeID. Unfollowing a user who
+// isn't followed is a no-op.
+func (s *SQLiteDB) Unfollow(followerID, followeeID int) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := checkUser(tx, followeeID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`, followerID, followeeID)
+		return err
+	})
+}
+
+// GetFollowers returns a page of the users following userID, ordered by when
+// they followed
+func (s *SQLiteDB) GetFollowers(userID int, page Page) ([]FollowUser, error) {
+	return s.followPage(userID, page, "follower_id", "followee_id")
+}
+
+// GetFollowing returns a page of the users userID follows, ordered by when
+// they were followed
+func (s *SQLiteDB) GetFollowing(userID int, page Page) ([]FollowUser, error) {
+	return s.followPage(userID, page, "followee_id", "follower_id")
+}
+
+// followPage lists the users in the other column of the follows where
+// userID is in the user column
+func (s *SQLiteDB) followPage(userID int, page Page, other, user string) ([]FollowUser, error) {
+	if err := checkUser(s.db, userID); err != nil {
+		return nil, err
+	}
+
+	clause, args := page.sql("follows.id", "follows."+user+" = ?", userID)
+	rows, err := s.db.Query(`SELECT follows.id, users.id, users.handle, follows.created_at FROM follows JOIN users ON users.id = follows.`+other+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []FollowUser{}
+	for rows.Next() {
+		var u FollowUser
+		if err := rows.Scan(&u.FollowID, &u.UserID, &u.Handle, &u.FollowedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// CountFollows returns how many followers userID has and how many users they follow
+func (s *SQLiteDB) CountFollows(userID int) (FollowCounts, error) {
+	if err := checkUser(s.db, userID); err != nil {
+		return FollowCounts{}, err
+	}
+	var counts FollowCounts
+	err := s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM follows WHERE followee_id = ?), (SELECT COUNT(*) FROM follows WHERE follower_id = ?)`,
+		userID, userID).Scan(&counts.Followers, &counts.Following)
+	return counts, err
+}
+
+// GetTimeline returns a page of the chirps by userID and the users they
+// follow, merged from each author's chirps when it is read
+func (s *SQLiteDB) GetTimeline(userID int, page Page) ([]Chirp, error) {
+	clause, args := page.sql("chirps.id", "NOT deleted AND (author_id = ? OR author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?))", userID, userID)
+	return s.queryChirps(`SELECT `+chirpColumns+` FROM chirps`+clause, args...)
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const mediaColumns = `id, owner_id, key, thumbnail_key, content_type, thumbnail_type, size, width, height, created_at`
+
+func scanMedia(row rowScanner) (Media, error) {
+	var m Media
+	err := row.Scan(&m.ID, &m.OwnerID, &m.Key, &m.ThumbnailKey, &m.ContentType, &m.ThumbnailType, &m.Size, &m.Width, &m.Height, &m.CreatedAt)
+	return m, err
+}
+
+// CreateMedia records an uploaded image, assigning its id and creation time
+func (s *SQLiteDB) CreateMedia(m Media) (Media, error) {
+	m.CreatedAt = time.Now().UTC()
+	res, err := s.db.Exec(`INSERT INTO media (`+mediaColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.newID(), m.OwnerID, m.Key, m.ThumbnailKey, m.ContentType, m.ThumbnailType, m.Size, m.Width, m.Height, m.CreatedAt)
+	if err != nil {
+		return Media{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Media{}, err
+	}
+	m.ID = int(id)
+	return m, nil
+}
+
+// GetMedia returns the media with the given id
+func (s *SQLiteDB) GetMedia(id int) (Media, error) {
+	m, err := scanMedia(s.db.QueryRow(`SELECT `+mediaColumns+` FROM media WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Media{}, ErrMediaNotFound
+	}
+	return m, err
+}
+
+// sqlAttachments returns the attachments for the media ids, which authorID
+// must have uploaded
+func sqlAttachments(tx *sql.Tx, authorID int, ids []int) ([]Attachment, error) {
+	if err := checkMediaIDs(ids); err != nil {
+		return nil, err
+	}
+	var attachments []Attachment
+	for _, id := range ids {
+		m, err := scanMedia(tx.QueryRow(`SELECT `+mediaColumns+` FROM media WHERE id = ?`, id))
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && m.OwnerID != authorID) {
+			return nil, ErrMediaNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, m.attachment())
+	}
+	return attachments, nil
+}
+
+// encodeAttachments returns the value stored in the chirps media column
+func encodeAttachments(attachments []Attachment) (string, error) {
+	if len(attachments) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(attachments)
+	return string(data), err
+}
+
+// encodeIDs and decodeIDs convert between ids and the space-separated list
+// stored for them
+func encodeIDs(ids []int) string {
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = strconv.Itoa(id)
+	}
+	return strings.Join(fields, " ")
+}
+
+func decodeIDs(s string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Fields(s) {
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+// resolveSQLMentions resolves the @handles in body against the users table,
+// leaving out users who blocked authorID or whom authorID blocked
+func resolveSQLMentions(tx *sql.Tx, authorID int, body string) ([]Mention, error) {
+	var lookupErr error
+	mentions := resolveMentions(body, func(handle string) (int, bool) {
+		var id int
+		err := tx.QueryRow(`SELECT id FROM users WHERE handle = ?`, handle).Scan(&id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			lookupErr = err
+		}
+		return id, err == nil
+	})
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	return dropBlockedMentions(mentions, func(userID int) (bool, error) {
+		return sqlBlocked(tx, authorID, userID)
+	})
+}
+
+// encodeMentions stores mentions as JSON, or an empty string if there are none
+func encodeMentions(mentions []Mention) (string, error) {
+	if len(mentions) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(mentions)
+	return string(data), err
+}
+
+// insertChirpMentions records the users the chirp mentions in chirp_mentions.
+// The chirps delete trigger removes them again.
+func insertChirpMentions(tx *sql.Tx, chirp Chirp) error {
+	for _, userID := range chirp.mentionedUsers() {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO chirp_mentions (user_id, chirp_id) VALUES (?, ?)`, userID, chirp.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMentions returns a page of the chirps that mention the user, ordered by id
+func (s *SQLiteDB) GetMentions(userID int, page Page) ([]Chirp, error) {
+	clause, args := page.sql("chirps.id", "chirp_mentions.user_id = ?", userID)
+	return s.queryChirps(`SELECT `+chirpColumns+` FROM chirps JOIN chirp_mentions ON chirp_mentions.chirp_id = chirps.id`+clause, args...)
+}
@@ -0,0 +1,549 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqlMigration upgrades (Up) or downgrades (Down) the SQLite schema by one version
+type sqlMigration struct {
+	Name string
+	Up   func(tx *sql.Tx) error
+	Down func(tx *sql.Tx) error
+}
+
+// execSQL returns a migration step that runs stmts
+func execSQL(stmts string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(stmts)
+		return err
+	}
+}
+
+// sqlMigrations is the ordered list of SQLite migrations. The schema version,
+// kept in PRAGMA user_version, is the number of migrations applied; never
+// reorder or remove entries, only append.
+var sqlMigrations = []sqlMigration{
+	{
+		Name: "create chirps and users",
+		Up: execSQL(`
+CREATE TABLE IF NOT EXISTS users (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	email         TEXT    NOT NULL UNIQUE,
+	password      TEXT    NOT NULL,
+	is_chirpy_red INTEGER NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email);
+
+CREATE TABLE IF NOT EXISTS chirps (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	author_id INTEGER NOT NULL,
+	body      TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_chirps_author_id ON chirps(author_id);
+`),
+		Down: execSQL(`
+DROP TABLE chirps;
+DROP TABLE users;
+`),
+	},
+	{
+		Name: "add revoked tokens",
+		Up: execSQL(`
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	token      TEXT     NOT NULL,
+	revoked_at DATETIME NOT NULL
+);
+`),
+		Down: execSQL(`DROP TABLE revoked_tokens;`),
+	},
+	{
+		Name: "add chirp timestamps",
+		Up: func(tx *sql.Tx) error {
+			// The real creation time of older chirps is unknown, so they
+			// are stamped with the time of the upgrade
+			if _, err := tx.Exec(`ALTER TABLE chirps ADD COLUMN created_at DATETIME NOT NULL DEFAULT 0`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`UPDATE chirps SET created_at = ?`, time.Now().UTC())
+			return err
+		},
+		Down: execSQL(`ALTER TABLE chirps DROP COLUMN created_at;`),
+	},
+	{
+		Name: "add chirp search index",
+		Up: execSQL(`
+CREATE VIRTUAL TABLE chirps_fts USING fts5(body, content='chirps', content_rowid='id');
+INSERT INTO chirps_fts(chirps_fts) VALUES ('rebuild');
+
+CREATE TRIGGER chirps_fts_insert AFTER INSERT ON chirps BEGIN
+	INSERT INTO chirps_fts(rowid, body) VALUES (new.id, new.body);
+END;
+CREATE TRIGGER chirps_fts_delete AFTER DELETE ON chirps BEGIN
+	INSERT INTO chirps_fts(chirps_fts, rowid, body) VALUES ('delete', old.id, old.body);
+END;
+CREATE TRIGGER chirps_fts_update AFTER UPDATE OF body ON chirps BEGIN
+	INSERT INTO chirps_fts(chirps_fts, rowid, body) VALUES ('delete', old.id, old.body);
+	INSERT INTO chirps_fts(rowid, body) VALUES (new.id, new.body);
+END;
+`),
+		Down: execSQL(`
+DROP TRIGGER chirps_fts_update;
+DROP TRIGGER chirps_fts_delete;
+DROP TRIGGER chirps_fts_insert;
+DROP TABLE chirps_fts;
+`),
+	},
+	{
+		Name: "add chirp tags",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+ALTER TABLE chirps ADD COLUMN tags TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE chirp_tags (
+	tag      TEXT    NOT NULL,
+	chirp_id INTEGER NOT NULL,
+	PRIMARY KEY (tag, chirp_id)
+);
+CREATE INDEX idx_chirp_tags_chirp_id ON chirp_tags(chirp_id);
+
+CREATE TRIGGER chirp_tags_delete AFTER DELETE ON chirps BEGIN
+	DELETE FROM chirp_tags WHERE chirp_id = old.id;
+END;
+`)
+			if err != nil {
+				return err
+			}
+			return backfillChirpTags(tx)
+		},
+		Down: execSQL(`
+DROP TRIGGER chirp_tags_delete;
+DROP TABLE chirp_tags;
+ALTER TABLE chirps DROP COLUMN tags;
+`),
+	},
+	{
+		// Mentions are resolved when a chirp is created, so existing
+		// chirps get none; only users need filling in
+		Name: "add user handles and mentions",
+		Up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`ALTER TABLE users ADD COLUMN handle TEXT NOT NULL DEFAULT ''`); err != nil {
+				return err
+			}
+			if err := backfillUserHandles(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`
+CREATE UNIQUE INDEX idx_users_handle ON users(handle);
+
+ALTER TABLE chirps ADD COLUMN mentions TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE chirp_mentions (
+	user_id  INTEGER NOT NULL,
+	chirp_id INTEGER NOT NULL,
+	PRIMARY KEY (user_id, chirp_id)
+);
+CREATE INDEX idx_chirp_mentions_chirp_id ON chirp_mentions(chirp_id);
+
+CREATE TRIGGER chirp_mentions_delete AFTER DELETE ON chirps BEGIN
+	DELETE FROM chirp_mentions WHERE chirp_id = old.id;
+END;
+`)
+			return err
+		},
+		Down: execSQL(`
+DROP TRIGGER chirp_mentions_delete;
+DROP TABLE chirp_mentions;
+ALTER TABLE chirps DROP COLUMN mentions;
+DROP INDEX idx_users_handle;
+ALTER TABLE users DROP COLUMN handle;
+`),
+	},
+	{
+		Name: "add chirp replies",
+		Up: execSQL(`
+ALTER TABLE chirps ADD COLUMN in_reply_to INTEGER;
+ALTER TABLE chirps ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX idx_chirps_in_reply_to ON chirps(in_reply_to);
+`),
+		Down: execSQL(`
+DELETE FROM chirps WHERE deleted;
+DROP INDEX idx_chirps_in_reply_to;
+ALTER TABLE chirps DROP COLUMN deleted;
+ALTER TABLE chirps DROP COLUMN in_reply_to;
+`),
+	},
+	{
+		Name: "add likes and rechirps",
+		Up: execSQL(`
+ALTER TABLE chirps ADD COLUMN like_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN rechirp_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE likes (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id    INTEGER  NOT NULL,
+	chirp_id   INTEGER  NOT NULL,
+	created_at DATETIME NOT NULL,
+	UNIQUE (user_id, chirp_id)
+);
+CREATE INDEX idx_likes_chirp_id ON likes(chirp_id);
+
+CREATE TABLE rechirps (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id    INTEGER  NOT NULL,
+	chirp_id   INTEGER  NOT NULL,
+	created_at DATETIME NOT NULL,
+	UNIQUE (user_id, chirp_id)
+);
+CREATE INDEX idx_rechirps_chirp_id ON rechirps(chirp_id);
+
+CREATE TRIGGER likes_insert AFTER INSERT ON likes BEGIN
+	UPDATE chirps SET like_count = like_count + 1 WHERE id = new.chirp_id;
+END;
+CREATE TRIGGER likes_delete AFTER DELETE ON likes BEGIN
+	UPDATE chirps SET like_count = like_count - 1 WHERE id = old.chirp_id;
+END;
+CREATE TRIGGER rechirps_insert AFTER INSERT ON rechirps BEGIN
+	UPDATE chirps SET rechirp_count = rechirp_count + 1 WHERE id = new.chirp_id;
+END;
+CREATE TRIGGER rechirps_delete AFTER DELETE ON rechirps BEGIN
+	UPDATE chirps SET rechirp_count = rechirp_count - 1 WHERE id = old.chirp_id;
+END;
+CREATE TRIGGER chirp_reactions_delete AFTER DELETE ON chirps BEGIN
+	DELETE FROM likes WHERE chirp_id = old.id;
+	DELETE FROM rechirps WHERE chirp_id = old.id;
+END;
+`),
+		Down: execSQL(`
+DROP TRIGGER chirp_reactions_delete;
+DROP TRIGGER rechirps_delete;
+DROP TRIGGER rechirps_insert;
+DROP TRIGGER likes_delete;
+DROP TRIGGER likes_insert;
+DROP TABLE rechirps;
+DROP TABLE likes;
+ALTER TABLE chirps DROP COLUMN rechirp_count;
+ALTER TABLE chirps DROP COLUMN like_count;
+`),
+	},
+	{
+		Name: "add quote chirps",
+		Up:   execSQL(`ALTER TABLE chirps ADD COLUMN quoted_chirp_id INTEGER;`),
+		Down: execSQL(`ALTER TABLE chirps DROP COLUMN quoted_chirp_id;`),
+	},
+	{
+		Name: "add chirp revisions",
+		Up: execSQL(`
+ALTER TABLE chirps ADD COLUMN edited_at DATETIME;
+
+CREATE TABLE chirp_revisions (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	chirp_id    INTEGER  NOT NULL,
+	body        TEXT     NOT NULL,
+	created_at  DATETIME NOT NULL,
+	replaced_at DATETIME NOT NULL
+);
+CREATE INDEX idx_chirp_revisions_chirp_id ON chirp_revisions(chirp_id);
+
+CREATE TRIGGER chirp_revisions_delete AFTER DELETE ON chirps BEGIN
+	DELETE FROM chirp_revisions WHERE chirp_id = old.id;
+END;
+`),
+		Down: execSQL(`
+DROP TRIGGER chirp_revisions_delete;
+DROP TABLE chirp_revisions;
+ALTER TABLE chirps DROP COLUMN edited_at;
+`),
+	},
+	{
+		// Older versions have no trash, so chirps in it are left behind as
+		// tombstones when downgrading
+		Name: "add chirp trash",
+		Up:   execSQL(`ALTER TABLE chirps ADD COLUMN deleted_at DATETIME;`),
+		Down: execSQL(`
+DELETE FROM likes WHERE chirp_id IN (SELECT id FROM chirps WHERE deleted_at IS NOT NULL);
+DELETE FROM rechirps WHERE chirp_id IN (SELECT id FROM chirps WHERE deleted_at IS NOT NULL);
+DELETE FROM chirp_revisions WHERE chirp_id IN (SELECT id FROM chirps WHERE deleted_at IS NOT NULL);
+UPDATE chirps SET author_id = 0, body = '', tags = '', mentions = '', quoted_chirp_id = NULL, edited_at = NULL WHERE deleted_at IS NOT NULL;
+ALTER TABLE chirps DROP COLUMN deleted_at;
+`),
+	},
+	{
+		Name: "add drafts",
+		Up: execSQL(`
+CREATE TABLE drafts (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	author_id       INTEGER  NOT NULL,
+	body            TEXT     NOT NULL,
+	in_reply_to     INTEGER,
+	quoted_chirp_id INTEGER,
+	created_at      DATETIME NOT NULL,
+	publish_at      DATETIME,
+	publish_error   TEXT     NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_drafts_author_id ON drafts(author_id);
+CREATE INDEX idx_drafts_publish_at ON drafts(publish_at);
+`),
+		Down: execSQL(`DROP TABLE drafts;`),
+	},
+	{
+		// Downgrading drops the attachments from chirps and drafts
+		Name: "add media",
+		Up: execSQL(`
+CREATE TABLE media (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner_id       INTEGER  NOT NULL,
+	key            TEXT     NOT NULL,
+	thumbnail_key  TEXT     NOT NULL,
+	content_type   TEXT     NOT NULL,
+	thumbnail_type TEXT     NOT NULL,
+	size           INTEGER  NOT NULL,
+	width          INTEGER  NOT NULL,
+	height         INTEGER  NOT NULL,
+	created_at     DATETIME NOT NULL
+);
+
+ALTER TABLE chirps ADD COLUMN media TEXT NOT NULL DEFAULT '';
+ALTER TABLE drafts ADD COLUMN media_ids TEXT NOT NULL DEFAULT '';
+`),
+		Down: execSQL(`
+ALTER TABLE drafts DROP COLUMN media_ids;
+ALTER TABLE chirps DROP COLUMN media;
+DROP TABLE media;
+`),
+	},
+	{
+		Name: "add follows",
+		Up: execSQL(`
+CREATE TABLE follows (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	follower_id INTEGER  NOT NULL,
+	followee_id INTEGER  NOT NULL,
+	created_at  DATETIME NOT NULL,
+	UNIQUE (follower_id, followee_id)
+);
+CREATE INDEX idx_follows_followee_id ON follows(followee_id);
+`),
+		Down: execSQL(`DROP TABLE follows;`),
+	},
+	{
+		Name: "add restrictions",
+		Up: execSQL(`
+CREATE TABLE restrictions (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id    INTEGER  NOT NULL,
+	target_id  INTEGER  NOT NULL,
+	kind       TEXT     NOT NULL,
+	created_at DATETIME NOT NULL,
+	UNIQUE (user_id, target_id, kind)
+);
+CREATE INDEX idx_restrictions_target_id ON restrictions(target_id);
+`),
+		Down: execSQL(`DROP TABLE restrictions;`),
+	},
+	{
+		// When existing users signed up isn't recorded, so they get the
+		// time of the migration
+		Name: "add user profiles",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN created_at DATETIME;
+`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`UPDATE users SET created_at = ?`, time.Now().UTC())
+			return err
+		},
+		Down: execSQL(`
+ALTER TABLE users DROP COLUMN created_at;
+ALTER TABLE users DROP COLUMN avatar_id;
+ALTER TABLE users DROP COLUMN bio;
+ALTER TABLE users DROP COLUMN display_name;
+`),
+	},
+	{
+		// Users who signed up before emails were verified are trusted
+		Name: "add email verification",
+		Up: execSQL(`
+ALTER TABLE users ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN verification_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN verification_sent_at DATETIME;
+UPDATE users SET email_verified = 1;
+`),
+		Down: execSQL(`
+ALTER TABLE users DROP COLUMN verification_sent_at;
+ALTER TABLE users DROP COLUMN verification_id;
+ALTER TABLE users DROP COLUMN email_verified;
+`),
+	},
+	{
+		Name: "add password resets",
+		Up: execSQL(`
+ALTER TABLE users ADD COLUMN reset_token_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN reset_sent_at DATETIME;
+ALTER TABLE users ADD COLUMN tokens_valid_after DATETIME;
+CREATE INDEX idx_users_reset_token_hash ON users(reset_token_hash) WHERE reset_token_hash != '';
+`),
+		Down: execSQL(`
+DROP INDEX idx_users_reset_token_hash;
+ALTER TABLE users DROP COLUMN tokens_valid_after;
+ALTER TABLE users DROP COLUMN reset_sent_at;
+ALTER TABLE users DROP COLUMN reset_token_hash;
+`),
+	},
+	{
+		// Emails are looked up ignoring case, those stored before they were
+		// lowercased included
+		Name: "index emails ignoring case",
+		Up:   execSQL(`CREATE INDEX idx_users_email_lower ON users(LOWER(email));`),
+		Down: execSQL(`DROP INDEX idx_users_email_lower;`),
+	},
+}
+
+// backfillUserHandles gives every existing user a handle derived from their
+// email, earlier users getting first pick
+func backfillUserHandles(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, email FROM users ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Email); err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	taken := map[string]bool{}
+	for _, user := range users {
+		handle := migrationHandle(user.Email, func(h string) bool { return taken[h] })
+		taken[handle] = true
+		if _, err := tx.Exec(`UPDATE users SET handle = ? WHERE id = ?`, handle, user.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillChirpTags extracts the tags of chirps created before they were stored
+func backfillChirpTags(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, body FROM chirps`)
+	if err != nil {
+		return err
+	}
+	var chirps []Chirp
+	for rows.Next() {
+		var chirp Chirp
+		if err := rows.Scan(&chirp.ID, &chirp.Body); err != nil {
+			rows.Close()
+			return err
+		}
+		if chirp.Tags = migrationTags(chirp.Body); chirp.Tags != nil {
+			chirps = append(chirps, chirp)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, chirp := range chirps {
+		if _, err := tx.Exec(`UPDATE chirps SET tags = ? WHERE id = ?`, strings.Join(chirp.Tags, " "), chirp.ID); err != nil {
+			return err
+		}
+		if err := insertChirpTags(tx, chirp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteDB) schemaVersion() (int, error) {
+	var version int
+	err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// MigrationStatus lists every SQLite migration and whether the database has it applied
+func (s *SQLiteDB) MigrationStatus() ([]MigrationStatus, error) {
+	current, err := s.schemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(sqlMigrations))
+	for i, m := range sqlMigrations {
+		status[i] = MigrationStatus{Version: i + 1, Name: m.Name, Applied: i < current}
+	}
+	return status, nil
+}
+
+// MigrateUp applies every pending migration, each in its own transaction
+func (s *SQLiteDB) MigrateUp() error {
+	current, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > len(sqlMigrations) {
+		return errors.New("database is newer than this version of chirpy")
+	}
+
+	for v := current; v < len(sqlMigrations); v++ {
+		if err := s.runMigration(sqlMigrations[v].Up, v+1); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", v+1, sqlMigrations[v].Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the most recently applied migration
+func (s *SQLiteDB) MigrateDown() error {
+	current, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return errors.New("no migrations to revert")
+	}
+	if current > len(sqlMigrations) {
+		return errors.New("database is newer than this version of chirpy")
+	}
+
+	m := sqlMigrations[current-1]
+	if err := s.runMigration(m.Down, current-1); err != nil {
+		return fmt.Errorf("migration %d (%s): %w", current, m.Name, err)
+	}
+	return nil
+}
+
+// runMigration runs step and records the new schema version in one transaction
+func (s *SQLiteDB) runMigration(step func(tx *sql.Tx) error, version int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := step(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// StartPasswordReset records token as the one that resets the password of
+// the user with email, replacing any sent before, unless the last one was
+// sent less than minInterval before now. Only a hash of token is stored.
+func (s *SQLiteDB) StartPasswordReset(email, token string, now time.Time, minInterval time.Duration) (User, error) {
+	var user User
+	err := s.withTx(func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE LOWER(email) = ?`, emailKey(email)))
+		if err != nil {
+			return err
+		}
+		if err := user.startPasswordReset(token, now, minInterval); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE users SET reset_token_hash = ?, reset_sent_at = ? WHERE id = ?`,
+			user.ResetTokenHash, user.ResetSentAt, user.ID)
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// ResetPassword sets a new password for the user token was sent to, if it
+// is their latest and was sent less than ttl before now. Each token works
+// once, and the user's refresh tokens issued before it are revoked.
+func (s *SQLiteDB) ResetPassword(token, password string, now time.Time, ttl time.Duration) (User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	var user User
+	err = s.withTx(func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE reset_token_hash = ? AND reset_token_hash != ''`,
+			hashResetToken(token)))
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrInvalidPasswordReset
+		}
+		if err != nil {
+			return err
+		}
+		if err := user.resetPassword(hashedPassword, now, ttl); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE users SET password = ?, reset_token_hash = '', tokens_valid_after = ? WHERE id = ?`,
+			user.Password, user.TokensValidAfter, user.ID)
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
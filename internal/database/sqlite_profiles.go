@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// GetProfile returns the public profile of the user, with how many chirps
+// they have posted and their follow counts
+func (s *SQLiteDB) GetProfile(userID int) (Profile, error) {
+	var counts FollowCounts
+	var chirpCount int
+	user, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+`,
+	(SELECT COUNT(*) FROM chirps WHERE author_id = users.id AND NOT deleted),
+	(SELECT COUNT(*) FROM follows WHERE followee_id = users.id),
+	(SELECT COUNT(*) FROM follows WHERE follower_id = users.id)
+FROM users WHERE id = ?`, userID), &chirpCount, &counts.Followers, &counts.Following)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	profile := user.profile()
+	profile.ChirpCount = chirpCount
+	profile.FollowCounts = counts
+	if user.AvatarID != 0 {
+		m, err := s.GetMedia(user.AvatarID)
+		if err != nil && !errors.Is(err, ErrMediaNotFound) {
+			return Profile{}, err
+		}
+		if err == nil {
+			avatar := m.attachment()
+			profile.Avatar = &avatar
+		}
+	}
+	return profile, nil
+}
+
+// UpdateProfile changes the user's handle, display name, bio or avatar. The
+// avatar must be an image the user uploaded.
+func (s *SQLiteDB) UpdateProfile(userID int, u ProfileUpdate) (User, error) {
+	if err := u.normalize(); err != nil {
+		return User{}, err
+	}
+
+	var user User
+	err := s.withTx(func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, userID))
+		if err != nil {
+			return err
+		}
+		if u.AvatarID != nil && *u.AvatarID != 0 {
+			if _, err := sqlAttachments(tx, userID, []int{*u.AvatarID}); err != nil {
+				return err
+			}
+		}
+
+		u.apply(&user)
+		_, err = tx.Exec(`UPDATE users SET handle = ?, display_name = ?, bio = ?, avatar_id = ? WHERE id = ?`,
+			user.Handle, user.DisplayName, user.Bio, user.AvatarID, userID)
+		if err != nil && isUniqueViolation(err) {
+			return ErrHandleTaken
+		}
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
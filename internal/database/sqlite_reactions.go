@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// React records userID reacting to the chirp in the way kind names and
+// returns the chirp with its updated counts. Reacting twice is a no-op.
+// Users can't react to the chirps of someone they blocked or who blocked them.
+// Triggers on the reaction tables keep the chirp's counts in step.
+func (s *SQLiteDB) React(kind string, userID, chirpID int) (Chirp, error) {
+	k, err := lookupReaction(kind)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	var chirp Chirp
+	err = s.withTx(func(tx *sql.Tx) error {
+		if err := checkChirp(tx, chirpID); err != nil {
+			return err
+		}
+		if err := checkChirpBlocked(tx, userID, chirpID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT OR IGNORE INTO `+k.entity+` (id, user_id, chirp_id, created_at) VALUES (?, ?, ?, ?)`,
+			s.newID(), userID, chirpID, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		chirp, err = scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirpID))
+		return err
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// Unreact removes userID's reaction of kind from the chirp and returns the
+// chirp with its updated counts. Removing a missing reaction is a no-op.
+func (s *SQLiteDB) Unreact(kind string, userID, chirpID int) (Chirp, error) {
+	k, err := lookupReaction(kind)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	var chirp Chirp
+	err = s.withTx(func(tx *sql.Tx) error {
+		if err := checkChirp(tx, chirpID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM `+k.entity+` WHERE user_id = ? AND chirp_id = ?`, userID, chirpID); err != nil {
+			return err
+		}
+		chirp, err = scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirpID))
+		return err
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// GetLikes returns a page of the chirps userID has liked, ordered by when
+// they were liked
+func (s *SQLiteDB) GetLikes(userID int, page Page) ([]LikedChirp, error) {
+	clause, args := page.sql("likes.id", "likes.user_id = ?", userID)
+	rows, err := s.db.Query(`SELECT `+chirpColumns+`, likes.id, likes.created_at FROM likes JOIN chirps ON chirps.id = likes.chirp_id`+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []LikedChirp{}
+	for rows.Next() {
+		var liked LikedChirp
+		if liked.Chirp, err = scanChirp(rows, &liked.LikeID, &liked.LikedAt); err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, liked)
+	}
+	return chirps, rows.Err()
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// sqlBlocked reports whether either user has blocked the other
+func sqlBlocked(q rowQuerier, a, b int) (bool, error) {
+	var blocked bool
+	err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM restrictions WHERE kind = ? AND ((user_id = ? AND target_id = ?) OR (user_id = ? AND target_id = ?)))`,
+		RestrictionBlock, a, b, b, a).Scan(&blocked)
+	return blocked, err
+}
+
+// checkChirpBlocked returns ErrBlocked if userID and the author of the chirp
+// have blocked each other
+func checkChirpBlocked(q rowQuerier, userID, chirpID int) error {
+	var authorID int
+	if err := q.QueryRow(`SELECT author_id FROM chirps WHERE id = ?`, chirpID).Scan(&authorID); err != nil {
+		return err
+	}
+	blocked, err := sqlBlocked(q, userID, authorID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// Restrict makes userID block or mute targetID, as kind says. Restricting
+// twice is a no-op. Blocking also ends any follows between the two.
+func (s *SQLiteDB) Restrict(kind string, userID, targetID int) error {
+	if err := checkRestriction(kind); err != nil {
+		return err
+	}
+	if userID == targetID {
+		return ErrRestrictSelf
+	}
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := checkUser(tx, targetID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT OR IGNORE INTO restrictions (id, user_id, target_id, kind, created_at) VALUES (?, ?, ?, ?, ?)`,
+			s.newID(), userID, targetID, kind, time.Now().UTC())
+		if err != nil || kind != RestrictionBlock {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM follows WHERE (follower_id = ? AND followee_id = ?) OR (follower_id = ? AND followee_id = ?)`,
+			userID, targetID, targetID, userID)
+		return err
+	})
+}
+
+// Unrestrict lifts userID's block or mute of targetID. Lifting a missing
+// restriction is a no-op; follows ended by a block are not restored.
+func (s *SQLiteDB) Unrestrict(kind string, userID, targetID int) error {
+	if err := checkRestriction(kind); err != nil {
+		return err
+	}
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := checkUser(tx, targetID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM restrictions WHERE user_id = ? AND target_id = ? AND kind = ?`, userID, targetID, kind)
+		return err
+	})
+}
+
+// GetRestricted returns a page of the users userID has blocked or muted, as
+// kind says, ordered by when they were
+func (s *SQLiteDB) GetRestricted(kind string, userID int, page Page) ([]RestrictedUser, error) {
+	if err := checkRestriction(kind); err != nil {
+		return nil, err
+	}
+
+	clause, args := page.sql("restrictions.id", "restrictions.user_id = ? AND restrictions.kind = ?", userID, kind)
+	rows, err := s.db.Query(`SELECT restrictions.id, users.id, users.handle, restrictions.created_at FROM restrictions JOIN users ON users.id = restrictions.target_id`+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []RestrictedUser{}
+	for rows.Next() {
+		var u RestrictedUser
+		if err := rows.Scan(&u.RestrictionID, &u.UserID, &u.Handle, &u.RestrictedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// GetRestrictions returns the users userID has blocked or muted and those
+// who have blocked them
+func (s *SQLiteDB) GetRestrictions(userID int) (Restrictions, error) {
+	rows, err := s.db.Query(`SELECT user_id, target_id, kind FROM restrictions WHERE user_id = ? OR (target_id = ? AND kind = ?) ORDER BY id`,
+		userID, userID, RestrictionBlock)
+	if err != nil {
+		return Restrictions{}, err
+	}
+	defer rows.Close()
+
+	var restrictions Restrictions
+	for rows.Next() {
+		var r Restriction
+		if err := rows.Scan(&r.UserID, &r.TargetID, &r.Kind); err != nil {
+			return Restrictions{}, err
+		}
+		switch {
+		case r.UserID != userID:
+			restrictions.BlockedBy = append(restrictions.BlockedBy, r.UserID)
+		case r.Kind == RestrictionBlock:
+			restrictions.Blocked = append(restrictions.Blocked, r.TargetID)
+		default:
+			restrictions.Muted = append(restrictions.Muted, r.TargetID)
+		}
+	}
+	return restrictions, rows.Err()
+}
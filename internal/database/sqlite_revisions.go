@@ -0,0 +1,105 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// EditChirp replaces the body of a chirp owned by authorID, keeping the old
+// body as a revision. Tags and mentions are extracted again from the new
+// body. A window above 0 limits how long after creation a chirp can be edited.
+func (s *SQLiteDB) EditChirp(authorID, id int, body string, window time.Duration) (Chirp, error) {
+	var chirp Chirp
+	err := s.withTx(func(tx *sql.Tx) error {
+		var err error
+		chirp, err = scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, id))
+		if errors.Is(err, sql.ErrNoRows) || chirp.Deleted {
+			return ErrChirpNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if chirp.AuthorID != authorID {
+			return ErrNotChirpAuthor
+		}
+		now := time.Now().UTC()
+		if window > 0 && now.Sub(chirp.CreatedAt) > window {
+			return ErrEditWindowClosed
+		}
+		if body == chirp.Body {
+			return nil
+		}
+
+		_, err = tx.Exec(`INSERT INTO chirp_revisions (id, chirp_id, body, created_at, replaced_at) VALUES (?, ?, ?, ?, ?)`,
+			s.newID(), id, chirp.Body, chirp.lastWritten(), now)
+		if err != nil {
+			return err
+		}
+
+		chirp.Body = body
+		chirp.Tags = extractTags(body)
+		if chirp.Mentions, err = resolveSQLMentions(tx, authorID, body); err != nil {
+			return err
+		}
+		mentions, err := encodeMentions(chirp.Mentions)
+		if err != nil {
+			return err
+		}
+		chirp.EditedAt = &now
+
+		_, err = tx.Exec(`UPDATE chirps SET body = ?, tags = ?, mentions = ?, edited_at = ? WHERE id = ?`,
+			body, strings.Join(chirp.Tags, " "), mentions, now, id)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range []string{
+			`DELETE FROM chirp_tags WHERE chirp_id = ?`,
+			`DELETE FROM chirp_mentions WHERE chirp_id = ?`,
+		} {
+			if _, err := tx.Exec(stmt, id); err != nil {
+				return err
+			}
+		}
+		if err := insertChirpTags(tx, chirp); err != nil {
+			return err
+		}
+		return insertChirpMentions(tx, chirp)
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// GetRevisions returns a page of the earlier bodies of a chirp, oldest first
+func (s *SQLiteDB) GetRevisions(chirpID int, page Page) ([]Revision, error) {
+	var revisions []Revision
+	err := s.withTx(func(tx *sql.Tx) error {
+		if err := checkChirp(tx, chirpID); err != nil {
+			return err
+		}
+
+		clause, args := page.sql("id", "chirp_id = ?", chirpID)
+		rows, err := tx.Query(`SELECT id, chirp_id, body, created_at, replaced_at FROM chirp_revisions`+clause, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		revisions = []Revision{}
+		for rows.Next() {
+			var revision Revision
+			if err := rows.Scan(&revision.ID, &revision.ChirpID, &revision.Body, &revision.CreatedAt, &revision.ReplacedAt); err != nil {
+				return err
+			}
+			revisions = append(revisions, revision)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// SearchChirps returns the chirps matching q, most relevant first. Matching
+// uses the chirps_fts full-text index; relevance is its bm25 score.
+func (s *SQLiteDB) SearchChirps(q SearchQuery) ([]Chirp, error) {
+	phrases := parsePhrases(q.Text)
+	if len(phrases) == 0 {
+		return nil, ErrEmptySearch
+	}
+
+	// Every word is made of letters and digits only, so quoting each phrase
+	// is enough to keep FTS5 from reading anything as query syntax
+	quoted := make([]string, len(phrases))
+	for i, phrase := range phrases {
+		quoted[i] = `"` + strings.Join(phrase, " ") + `"`
+	}
+
+	query := `
+SELECT ` + chirpColumns + `, bm25(chirps_fts)
+FROM chirps_fts JOIN chirps ON chirps.id = chirps_fts.rowid
+WHERE chirps_fts MATCH ? AND NOT chirps.deleted`
+	args := []interface{}{strings.Join(quoted, " ")}
+	if q.AuthorID != 0 {
+		query += ` AND chirps.author_id = ?`
+		args = append(args, q.AuthorID)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []searchHit
+	for rows.Next() {
+		var bm25 float64
+		chirp, err := scanChirp(rows, &bm25)
+		if err != nil {
+			return nil, err
+		}
+		hit := searchHit{chirp: chirp}
+		if !q.matches(hit.chirp) {
+			continue
+		}
+		// bm25 is negative, lower for better matches
+		hit.relevance = -bm25
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return q.rank(hits, time.Now().UTC()), nil
+}
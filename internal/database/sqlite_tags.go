@@ -0,0 +1,52 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// insertChirpTags records the chirp's tags in chirp_tags. The chirps delete
+// trigger removes them again.
+func insertChirpTags(tx *sql.Tx, chirp Chirp) error {
+	for _, tag := range chirp.Tags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO chirp_tags (tag, chirp_id) VALUES (?, ?)`, tag, chirp.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetChirpsByTag returns a page of the chirps tagged with tag, ordered by id
+func (s *SQLiteDB) GetChirpsByTag(tag string, page Page) ([]Chirp, error) {
+	clause, args := page.sql("chirps.id", "chirp_tags.tag = ?", tag)
+	return s.queryChirps(`SELECT `+chirpColumns+` FROM chirps JOIN chirp_tags ON chirp_tags.chirp_id = chirps.id`+clause, args...)
+}
+
+// TrendingTags counts the tags of chirps created since the given time and
+// returns them most used first
+func (s *SQLiteDB) TrendingTags(since time.Time, offset, limit int) ([]TagCount, error) {
+	if limit == 0 {
+		limit = -1
+	}
+	rows, err := s.db.Query(`
+SELECT chirp_tags.tag, COUNT(*) AS uses
+FROM chirp_tags JOIN chirps ON chirps.id = chirp_tags.chirp_id
+WHERE chirps.created_at >= ?
+GROUP BY chirp_tags.tag
+ORDER BY uses DESC, chirp_tags.tag
+LIMIT ? OFFSET ?`, since.UTC(), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []TagCount{}
+	for rows.Next() {
+		var count TagCount
+		if err := rows.Scan(&count.Tag, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
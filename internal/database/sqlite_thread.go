@@ -0,0 +1,47 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// GetThread returns the thread around the chirp with the given id, with
+// depth levels of replies
+func (s *SQLiteDB) GetThread(id int, page Page, depth int) (Thread, error) {
+	// Read the whole thread from one snapshot of the database
+	var thread Thread
+	err := s.withTx(func(tx *sql.Tx) error {
+		var err error
+		thread, err = buildThread(sqliteThreadReader{tx}, id, page, depth)
+		return err
+	})
+	return thread, err
+}
+
+// sqliteThreadReader reads threads inside a transaction
+type sqliteThreadReader struct {
+	tx *sql.Tx
+}
+
+func (r sqliteThreadReader) chirp(id int) (Chirp, bool, error) {
+	chirp, err := scanChirp(r.tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, false, nil
+	}
+	return chirp, err == nil, err
+}
+
+func (r sqliteThreadReader) replies(id int, page Page) ([]Chirp, error) {
+	clause, args := page.sql("chirps.id", "in_reply_to = ?", id)
+	rows, err := r.tx.Query(`SELECT `+chirpColumns+` FROM chirps`+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanChirps(rows)
+}
+
+func (r sqliteThreadReader) replyCount(id int) (int, error) {
+	var n int
+	err := r.tx.QueryRow(`SELECT COUNT(*) FROM chirps WHERE in_reply_to = ?`, id).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// GetTrash returns a page of the chirps authorID has in the trash, ordered by id
+func (s *SQLiteDB) GetTrash(authorID int, page Page) ([]Chirp, error) {
+	clause, args := page.sql("chirps.id", "author_id = ? AND deleted_at IS NOT NULL", authorID)
+	rows, err := s.db.Query(`SELECT `+chirpColumns+` FROM chirps`+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanStoredChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+// RestoreChirp takes a chirp owned by authorID back out of the trash
+func (s *SQLiteDB) RestoreChirp(authorID, id int) (Chirp, error) {
+	var chirp Chirp
+	err := s.withTx(func(tx *sql.Tx) error {
+		var err error
+		chirp, err = scanStoredChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, id))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err != nil || !chirp.trashed() || chirp.AuthorID != authorID {
+			return ErrChirpNotFound
+		}
+
+		if _, err := tx.Exec(`UPDATE chirps SET deleted = 0, deleted_at = NULL WHERE id = ?`, id); err != nil {
+			return err
+		}
+		if err := insertChirpTags(tx, chirp); err != nil {
+			return err
+		}
+		if err := insertChirpMentions(tx, chirp); err != nil {
+			return err
+		}
+		chirp.Deleted = false
+		chirp.DeletedAt = nil
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// PurgeTrash permanently deletes the chirps put in the trash before the given
+// time and returns how many there were
+func (s *SQLiteDB) PurgeTrash(before time.Time) (int, error) {
+	var purged int
+	err := s.withTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT id FROM chirps WHERE deleted_at < ? ORDER BY id`, before.UTC())
+		if err != nil {
+			return err
+		}
+		var ids []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := purgeSQLChirp(tx, id); err != nil {
+				return err
+			}
+		}
+		purged = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// purgeSQLChirp permanently deletes a chirp in the trash. A chirp with
+// replies is replaced by its tombstone so the thread stays whole; tombstones
+// left with no replies are removed. The chirps delete triggers take its
+// reactions and revisions with it.
+func purgeSQLChirp(tx *sql.Tx, id int) error {
+	var parent sql.NullInt64
+	var replies int
+	err := tx.QueryRow(`
+SELECT in_reply_to, (SELECT COUNT(*) FROM chirps AS reply WHERE reply.in_reply_to = chirps.id)
+FROM chirps WHERE id = ?`, id).Scan(&parent, &replies)
+	if err != nil {
+		return err
+	}
+
+	if replies > 0 {
+		for _, stmt := range []string{
+			`UPDATE chirps SET author_id = 0, body = '', tags = '', mentions = '', media = '', quoted_chirp_id = NULL, edited_at = NULL, deleted_at = NULL WHERE id = ?`,
+			`DELETE FROM likes WHERE chirp_id = ?`,
+			`DELETE FROM rechirps WHERE chirp_id = ?`,
+			`DELETE FROM chirp_revisions WHERE chirp_id = ?`,
+		} {
+			if _, err := tx.Exec(stmt, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, err := tx.Exec(`DELETE FROM chirps WHERE id = ?`, id); err != nil {
+		return err
+	}
+	// Remove tombstones above the chirp that no longer hold up any replies
+	for parent.Valid {
+		var grandparent sql.NullInt64
+		err := tx.QueryRow(`
+SELECT in_reply_to, (SELECT COUNT(*) FROM chirps AS reply WHERE reply.in_reply_to = chirps.id)
+FROM chirps WHERE id = ? AND deleted AND deleted_at IS NULL`, parent.Int64).Scan(&grandparent, &replies)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil || replies > 0 {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM chirps WHERE id = ?`, parent.Int64); err != nil {
+			return err
+		}
+		parent = grandparent
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// StartEmailVerification records tokenID as the token that verifies the
+// user's email, replacing any sent before, unless the last one was sent less
+// than minInterval before now
+func (s *SQLiteDB) StartEmailVerification(userID int, tokenID string, now time.Time, minInterval time.Duration) (User, error) {
+	return s.updateVerification(userID, func(user *User) error {
+		return user.startVerification(tokenID, now, minInterval)
+	})
+}
+
+// VerifyEmail marks the user's email verified if tokenID is the latest
+// verification token sent to them, at email
+func (s *SQLiteDB) VerifyEmail(userID int, tokenID, email string) (User, error) {
+	return s.updateVerification(userID, func(user *User) error {
+		return user.verify(tokenID, email)
+	})
+}
+
+// updateVerification applies fn to the user and saves their verification state
+func (s *SQLiteDB) updateVerification(userID int, fn func(user *User) error) (User, error) {
+	var user User
+	err := s.withTx(func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, userID))
+		if err != nil {
+			return err
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE users SET email_verified = ?, verification_id = ?, verification_sent_at = ? WHERE id = ?`,
+			user.EmailVerified, user.VerificationID, user.VerificationSentAt, userID)
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
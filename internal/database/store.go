@@ -0,0 +1,132 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Store is the storage backend used by the handlers. The JSON file database
+// (DB) and the SQLite database (SQLiteDB) both implement it.
+type Store interface {
+	CreateChirp(c NewChirp) (Chirp, error)
+	GetChirps(page Page) ([]Chirp, error)
+	GetChirp(id int) (Chirp, error)
+	GetChirpsByAuthor(authorID int, page Page) ([]Chirp, error)
+	SearchChirps(q SearchQuery) ([]Chirp, error)
+	GetChirpsByTag(tag string, page Page) ([]Chirp, error)
+	TrendingTags(since time.Time, offset, limit int) ([]TagCount, error)
+	EditChirp(authorID, id int, body string, window time.Duration) (Chirp, error)
+	GetRevisions(chirpID int, page Page) ([]Revision, error)
+	DeleteChirp(authorID, id int) error
+	GetTrash(authorID int, page Page) ([]Chirp, error)
+	RestoreChirp(authorID, id int) (Chirp, error)
+	PurgeTrash(before time.Time) (int, error)
+
+	GetMentions(userID int, page Page) ([]Chirp, error)
+	GetThread(id int, page Page, depth int) (Thread, error)
+
+	// React and Unreact add and remove a user's ReactionLike or
+	// ReactionRechirp on a chirp, returning the chirp with its new counts
+	React(kind string, userID, chirpID int) (Chirp, error)
+	Unreact(kind string, userID, chirpID int) (Chirp, error)
+	GetLikes(userID int, page Page) ([]LikedChirp, error)
+
+	// Drafts with a PublishAt time are published by the server's scheduler
+	// once DueDrafts returns them
+	CreateDraft(c NewChirp, publishAt *time.Time) (Draft, error)
+	GetDrafts(authorID int, scheduled bool, page Page) ([]Draft, error)
+	DeleteDraft(authorID, id int) error
+	DueDrafts(now time.Time) ([]Draft, error)
+	PublishDraft(authorID, id int) (Chirp, error)
+
+	// CreateMedia records an image stored in a blob store, which chirps can
+	// then attach by id
+	CreateMedia(m Media) (Media, error)
+	GetMedia(id int) (Media, error)
+
+	CreateUser(email, password, handle string) (User, error)
+	GetUser(userID int) (User, error)
+	GetUserbyEmail(email string) (User, error)
+	GetUserByHandle(handle string) (User, error)
+	UpdateUser(userID int, email, password, handle string) (User, error)
+	UpdateMembership(userID int, membership bool) (User, error)
+	// GetProfile returns the view of a user that is safe to show anyone
+	GetProfile(userID int) (Profile, error)
+	UpdateProfile(userID int, u ProfileUpdate) (User, error)
+	// StartEmailVerification records the one token that can verify a user's
+	// email, and VerifyEmail uses it up
+	StartEmailVerification(userID int, tokenID string, now time.Time, minInterval time.Duration) (User, error)
+	VerifyEmail(userID int, tokenID, email string) (User, error)
+	// StartPasswordReset records the hash of the one token that can reset a
+	// user's password, and ResetPassword uses it up
+	StartPasswordReset(email, token string, now time.Time, minInterval time.Duration) (User, error)
+	ResetPassword(token, password string, now time.Time, ttl time.Duration) (User, error)
+
+	// Follow and Unfollow are idempotent. GetTimeline returns the chirps of
+	// a user and everyone they follow.
+	Follow(followerID, followeeID int) error
+	Unfollow(followerID, followeeID int) error
+	GetFollowers(userID int, page Page) ([]FollowUser, error)
+	GetFollowing(userID int, page Page) ([]FollowUser, error)
+	CountFollows(userID int) (FollowCounts, error)
+	GetTimeline(userID int, page Page) ([]Chirp, error)
+
+	// Restrict and Unrestrict add and remove a RestrictionBlock or
+	// RestrictionMute, idempotently. GetRestrictions returns everything
+	// ForViewer needs to filter what a user reads.
+	Restrict(kind string, userID, targetID int) error
+	Unrestrict(kind string, userID, targetID int) error
+	GetRestricted(kind string, userID int, page Page) ([]RestrictedUser, error)
+	GetRestrictions(userID int) (Restrictions, error)
+
+	RevokeToken(tokenID string, revokedAt time.Time) (RevokedToken, error)
+
+	// Backup writes a consistent, gzip-compressed snapshot of the store to w
+	Backup(w io.Writer) error
+	Close() error
+}
+
+// Options configures a store
+type Options struct {
+	// IDs selects how new ids are generated, IDSequence (the default) or
+	// IDSnowflake
+	IDs string
+	// NodeID, from 0 to 63, distinguishes servers sharing an id space when
+	// IDs is IDSnowflake
+	NodeID int
+	// MasterKey, if set, encrypts the database at rest (see crypt.go). Only
+	// the JSON store supports encryption.
+	MasterKey []byte
+}
+
+var errEncryptionUnsupported = errors.New("encryption at rest is only supported by the json driver")
+
+// Open opens the store named by driver ("json" or "sqlite") at path
+func Open(driver, path string, opts Options) (Store, error) {
+	switch driver {
+	case "", "json":
+		return NewDB(path, opts)
+	case "sqlite":
+		if opts.MasterKey != nil {
+			return nil, errEncryptionUnsupported
+		}
+		return NewSQLiteDB(path, opts)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
+
+// newIDGenerator returns the snowflake generator if opts ask for one, or nil
+// for per-entity sequences
+func newIDGenerator(opts Options) (*snowflake, error) {
+	switch opts.IDs {
+	case "", IDSequence:
+		return nil, nil
+	case IDSnowflake:
+		return newSnowflake(opts.NodeID)
+	default:
+		return nil, fmt.Errorf("unknown id generator %q", opts.IDs)
+	}
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TagCount is how many recent chirps used a tag
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// extractTags returns the #tags in body, lowercased and without the #, in
+// order of first use. A tag starts at a # that doesn't follow a letter or
+// digit and runs over letters, digits and underscores; it must contain a
+// letter, so "#1" is not a tag.
+func extractTags(body string) []string {
+	var tags []string
+	seen := map[string]bool{}
+
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' || (i > 0 && isTagRune(runes[i-1])) {
+			continue
+		}
+		end := i + 1
+		hasLetter := false
+		for end < len(runes) && isTagRune(runes[end]) {
+			hasLetter = hasLetter || unicode.IsLetter(runes[end])
+			end++
+		}
+		if hasLetter {
+			tag := strings.ToLower(string(runes[i+1 : end]))
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		i = end - 1
+	}
+	return tags
+}
+
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_'
+}
+
+// NormalizeTag turns a tag as a user may write it, such as "#Golang", into
+// the form stored on chirps
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// rankTags sorts counts by count, then tag, and returns the window selected
+// by offset and limit
+func rankTags(counts map[string]int, offset, limit int) []TagCount {
+	ranked := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		ranked = append(ranked, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Tag < ranked[j].Tag
+	})
+
+	if offset >= len(ranked) {
+		return []TagCount{}
+	}
+	ranked = ranked[offset:]
+	if limit != 0 && limit < len(ranked) {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+// GetChirpsByTag returns a page of the chirps tagged with tag, ordered by id
+func (db *DB) GetChirpsByTag(tag string, page Page) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.chirpPage(page.window(db.index.chirpsByTag[tag])), nil
+}
+
+// TrendingTags counts the tags of chirps created since the given time and
+// returns them most used first
+func (db *DB) TrendingTags(since time.Time, offset, limit int) ([]TagCount, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	counts := map[string]int{}
+	for tag, ids := range db.index.chirpsByTag {
+		// Ids are handed out in creation order, so walk back from the
+		// newest chirp until one falls outside the window
+		for i := len(ids) - 1; i >= 0; i-- {
+			if db.data.Chirps[ids[i]].CreatedAt.Before(since) {
+				break
+			}
+			counts[tag]++
+		}
+	}
+	return rankTags(counts, offset, limit), nil
+}
@@ -0,0 +1,113 @@
+package database
+
+// maxNestedReplies caps the replies shown under each reply in a thread;
+// ReplyCount tells clients when there are more to fetch
+const maxNestedReplies = 20
+
+// Thread is a chirp in the context of its conversation
+type Thread struct {
+	// Ancestors are the chirps the chirp replies to, oldest first
+	Ancestors []Chirp `json:"ancestors"`
+	Chirp     Chirp   `json:"chirp"`
+	// Replies is a page of the chirp's direct replies, each with its own
+	// replies nested below it
+	Replies []ThreadNode `json:"replies"`
+}
+
+// ThreadNode is a reply and the replies nested below it
+type ThreadNode struct {
+	Chirp
+	// ReplyCount counts every direct reply, including any not nested here
+	ReplyCount int          `json:"reply_count"`
+	Replies    []ThreadNode `json:"replies,omitempty"`
+}
+
+// threadReader is what buildThread needs from a store
+type threadReader interface {
+	chirp(id int) (Chirp, bool, error)
+	replies(id int, page Page) ([]Chirp, error)
+	replyCount(id int) (int, error)
+}
+
+// buildThread assembles the thread around the chirp with the given id: all
+// of its ancestors, the page of its direct replies, and below each reply up
+// to depth-1 further levels of replies
+func buildThread(r threadReader, id int, page Page, depth int) (Thread, error) {
+	chirp, ok, err := r.chirp(id)
+	if err != nil {
+		return Thread{}, err
+	}
+	if !ok {
+		return Thread{}, ErrChirpNotFound
+	}
+	thread := Thread{Ancestors: []Chirp{}, Chirp: chirp}
+
+	for parentID := chirp.InReplyTo; parentID != 0; {
+		parent, ok, err := r.chirp(parentID)
+		if err != nil {
+			return Thread{}, err
+		}
+		if !ok {
+			break
+		}
+		thread.Ancestors = append([]Chirp{parent}, thread.Ancestors...)
+		parentID = parent.InReplyTo
+	}
+
+	replies, err := r.replies(id, page)
+	if err != nil {
+		return Thread{}, err
+	}
+	thread.Replies, err = threadNodes(r, replies, depth-1)
+	return thread, err
+}
+
+// threadNodes wraps chirps in nodes, nesting depth levels of replies below them
+func threadNodes(r threadReader, chirps []Chirp, depth int) ([]ThreadNode, error) {
+	nodes := make([]ThreadNode, len(chirps))
+	for i, chirp := range chirps {
+		count, err := r.replyCount(chirp.ID)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = ThreadNode{Chirp: chirp, ReplyCount: count}
+
+		if depth > 0 && count > 0 {
+			replies, err := r.replies(chirp.ID, Page{Limit: maxNestedReplies})
+			if err != nil {
+				return nil, err
+			}
+			if nodes[i].Replies, err = threadNodes(r, replies, depth-1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// GetThread returns the thread around the chirp with the given id, with
+// depth levels of replies
+func (db *DB) GetThread(id int, page Page, depth int) (Thread, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return buildThread(jsonThreadReader{db}, id, page, depth)
+}
+
+// jsonThreadReader reads threads from the in-memory dataset. The caller must hold the lock.
+type jsonThreadReader struct {
+	db *DB
+}
+
+func (r jsonThreadReader) chirp(id int) (Chirp, bool, error) {
+	chirp, ok := r.db.data.Chirps[id]
+	return r.db.view(chirp), ok, nil
+}
+
+func (r jsonThreadReader) replies(id int, page Page) ([]Chirp, error) {
+	return r.db.chirpPage(page.window(r.db.index.replies[id])), nil
+}
+
+func (r jsonThreadReader) replyCount(id int) (int, error) {
+	return len(r.db.index.replies[id]), nil
+}
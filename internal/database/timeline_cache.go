@@ -0,0 +1,318 @@
+package database
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// TimelineCache is a Store that keeps the newest chirps of each reader's
+// timeline in memory, in front of the fan-out on read done by the store.
+// Chirps by authors with at most maxFollowers followers are pushed into
+// their followers' cached timelines as they are posted (fan-out on write).
+// Chirps by more followed authors are not; a cached timeline of someone
+// following such an author is rebuilt from the store when next read.
+//
+// Deleted chirps are left in the cache and skipped when it is read;
+// restored chirps are pushed again like new ones.
+//
+// At most maxTimelines timelines are cached; the least recently read one is
+// dropped to make room for another.
+type TimelineCache struct {
+	Store
+	maxFollowers int
+	size         int
+	maxTimelines int
+
+	mux       sync.Mutex
+	timelines map[int]*cachedTimeline
+	// recent holds the user ids of the cached timelines, most recently
+	// read first
+	recent *list.List
+	// gen counts the chirps that weren't pushed to followers, and unpushed
+	// holds the gen of each author's latest one
+	gen      int
+	unpushed map[int]int
+	// building holds the timelines being read from the store, so readers
+	// wait for one build rather than start their own
+	building map[int]*timelineBuild
+}
+
+// timelineBuild is a cached timeline being read from the store without the
+// lock held
+type timelineBuild struct {
+	done chan struct{}
+	// pushed are the chirps pushed to the timeline while it was read
+	pushed []Chirp
+	// stale is set if the timeline was invalidated while it was read, so it
+	// isn't cached
+	stale bool
+
+	// Set before done is closed
+	ids      []int
+	complete bool
+	err      error
+}
+
+// cachedTimeline is the newest part of a user's timeline
+type cachedTimeline struct {
+	// ids are the chirp ids in the timeline, oldest first
+	ids []int
+	// complete is set if ids holds the whole timeline
+	complete bool
+	// authors are the user and the users they followed when it was built
+	authors map[int]bool
+	// gen is TimelineCache.gen when it was built
+	gen int
+	// elem is the timeline's element in TimelineCache.recent
+	elem *list.Element
+}
+
+// NewTimelineCache returns s with the newest size chirps of up to
+// maxTimelines timelines cached, pushing new chirps to the timelines of up to
+// maxFollowers followers
+func NewTimelineCache(s Store, maxFollowers, size, maxTimelines int) *TimelineCache {
+	return &TimelineCache{
+		Store:        s,
+		maxFollowers: maxFollowers,
+		size:         size,
+		maxTimelines: maxTimelines,
+		timelines:    make(map[int]*cachedTimeline),
+		recent:       list.New(),
+		unpushed:     make(map[int]int),
+		building:     make(map[int]*timelineBuild),
+	}
+}
+
+// CreateChirp creates the chirp and pushes it to the cached timelines
+func (c *TimelineCache) CreateChirp(nc NewChirp) (Chirp, error) {
+	chirp, err := c.Store.CreateChirp(nc)
+	if err != nil {
+		return Chirp{}, err
+	}
+	c.fanOut(chirp)
+	return chirp, nil
+}
+
+// PublishDraft publishes the draft and pushes the chirp to the cached timelines
+func (c *TimelineCache) PublishDraft(authorID, id int) (Chirp, error) {
+	chirp, err := c.Store.PublishDraft(authorID, id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	c.fanOut(chirp)
+	return chirp, nil
+}
+
+// RestoreChirp restores the chirp and pushes it back to the cached timelines
+func (c *TimelineCache) RestoreChirp(authorID, id int) (Chirp, error) {
+	chirp, err := c.Store.RestoreChirp(authorID, id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	c.fanOut(chirp)
+	return chirp, nil
+}
+
+// Follow follows the user and drops the follower's cached timeline
+func (c *TimelineCache) Follow(followerID, followeeID int) error {
+	err := c.Store.Follow(followerID, followeeID)
+	c.invalidate(followerID)
+	return err
+}
+
+// Unfollow unfollows the user and drops the follower's cached timeline
+func (c *TimelineCache) Unfollow(followerID, followeeID int) error {
+	err := c.Store.Unfollow(followerID, followeeID)
+	c.invalidate(followerID)
+	return err
+}
+
+// Restrict blocks or mutes the user. A block can end follows both ways, so
+// it drops both users' cached timelines.
+func (c *TimelineCache) Restrict(kind string, userID, targetID int) error {
+	err := c.Store.Restrict(kind, userID, targetID)
+	if kind == RestrictionBlock {
+		c.invalidate(userID)
+		c.invalidate(targetID)
+	}
+	return err
+}
+
+func (c *TimelineCache) invalidate(userID int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.drop(userID)
+	if b, ok := c.building[userID]; ok {
+		b.stale = true
+	}
+}
+
+// drop removes userID's cached timeline. The caller must hold the lock.
+func (c *TimelineCache) drop(userID int) {
+	if t, ok := c.timelines[userID]; ok {
+		c.recent.Remove(t.elem)
+		delete(c.timelines, userID)
+	}
+}
+
+// fanOut pushes a new chirp to the cached timelines of its author and, if
+// they have few enough, their followers
+func (c *TimelineCache) fanOut(chirp Chirp) {
+	followers, err := c.Store.GetFollowers(chirp.AuthorID, Page{Limit: c.maxFollowers + 1})
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.push(chirp.AuthorID, chirp)
+	if err != nil || len(followers) > c.maxFollowers {
+		c.gen++
+		c.unpushed[chirp.AuthorID] = c.gen
+		return
+	}
+	for _, f := range followers {
+		c.push(f.UserID, chirp)
+	}
+}
+
+// push adds the chirp to userID's cached timeline, if they have one and
+// follow its author, or keeps it for the timeline being built. The caller
+// must hold the lock.
+func (c *TimelineCache) push(userID int, chirp Chirp) {
+	if b, ok := c.building[userID]; ok {
+		b.pushed = append(b.pushed, chirp)
+	}
+	if t, ok := c.timelines[userID]; ok {
+		c.insert(t, chirp)
+	}
+}
+
+// insert adds the chirp to t if its author is one of t's, keeping only the
+// newest c.size chirps
+func (c *TimelineCache) insert(t *cachedTimeline, chirp Chirp) {
+	if !t.authors[chirp.AuthorID] {
+		return
+	}
+	i := sort.SearchInts(t.ids, chirp.ID)
+	if i < len(t.ids) && t.ids[i] == chirp.ID {
+		return
+	}
+	t.ids = append(t.ids, 0)
+	copy(t.ids[i+1:], t.ids[i:])
+	t.ids[i] = chirp.ID
+	if len(t.ids) > c.size {
+		t.ids = t.ids[len(t.ids)-c.size:]
+		t.complete = false
+	}
+}
+
+// GetTimeline serves newest-first pages from the cache, falling back to the
+// store for pages reaching past the cached chirps
+func (c *TimelineCache) GetTimeline(userID int, page Page) ([]Chirp, error) {
+	if !page.Desc {
+		return c.Store.GetTimeline(userID, page)
+	}
+	ids, complete, err := c.cached(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	end := len(ids)
+	if page.After != 0 {
+		end = sort.SearchInts(ids, page.After)
+	}
+	chirps := []Chirp{}
+	for i := end - 1; i >= 0 && (page.Limit == 0 || len(chirps) < page.Limit); i-- {
+		chirp, err := c.Store.GetChirp(ids[i])
+		if errors.Is(err, ErrChirpNotFound) || chirp.Deleted {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	if !complete && (page.Limit == 0 || len(chirps) < page.Limit) {
+		return c.Store.GetTimeline(userID, page)
+	}
+	return chirps, nil
+}
+
+// cached returns the ids in userID's cached timeline, building it if it is
+// missing or misses chirps that weren't pushed to it. The timeline is read
+// from the store without the lock held; chirps pushed meanwhile are added
+// to it before it is cached.
+func (c *TimelineCache) cached(userID int) (ids []int, complete bool, err error) {
+	c.mux.Lock()
+	t, ok := c.timelines[userID]
+	if ok {
+		for authorID := range t.authors {
+			if c.unpushed[authorID] > t.gen {
+				ok = false
+				break
+			}
+		}
+	}
+	if ok {
+		c.recent.MoveToFront(t.elem)
+		ids, complete = append([]int(nil), t.ids...), t.complete
+		c.mux.Unlock()
+		return ids, complete, nil
+	}
+	if b, ok := c.building[userID]; ok {
+		c.mux.Unlock()
+		<-b.done
+		return b.ids, b.complete, b.err
+	}
+	b := &timelineBuild{done: make(chan struct{})}
+	c.building[userID] = b
+	gen := c.gen
+	c.mux.Unlock()
+
+	t, err = c.build(userID, gen)
+
+	c.mux.Lock()
+	delete(c.building, userID)
+	if err == nil {
+		for _, chirp := range b.pushed {
+			c.insert(t, chirp)
+		}
+		if !b.stale {
+			c.drop(userID)
+			for len(c.timelines) >= c.maxTimelines {
+				c.drop(c.recent.Back().Value.(int))
+			}
+			t.elem = c.recent.PushFront(userID)
+			c.timelines[userID] = t
+		}
+		b.ids, b.complete = append([]int(nil), t.ids...), t.complete
+	}
+	b.err = err
+	c.mux.Unlock()
+	close(b.done)
+	return b.ids, b.complete, b.err
+}
+
+// build reads the newest part of userID's timeline from the store, as of
+// gen. It is called without the lock held.
+func (c *TimelineCache) build(userID, gen int) (*cachedTimeline, error) {
+	t := &cachedTimeline{authors: map[int]bool{userID: true}, gen: gen}
+	following, err := c.Store.GetFollowing(userID, Page{})
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range following {
+		t.authors[f.UserID] = true
+	}
+
+	chirps, err := c.Store.GetTimeline(userID, Page{Desc: true, Limit: c.size})
+	if err != nil {
+		return nil, err
+	}
+	for i := len(chirps) - 1; i >= 0; i-- {
+		t.ids = append(t.ids, chirps[i].ID)
+	}
+	t.complete = len(chirps) < c.size
+	return t, nil
+}
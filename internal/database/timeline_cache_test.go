@@ -0,0 +1,63 @@
+package database
+
+import "testing"
+
+// pausedStore holds GetTimeline up after reading from the store until
+// resume is closed, once paused is closed
+type pausedStore struct {
+	Store
+	paused, resume chan struct{}
+}
+
+func (s *pausedStore) GetTimeline(userID int, page Page) ([]Chirp, error) {
+	chirps, err := s.Store.GetTimeline(userID, page)
+	close(s.paused)
+	<-s.resume
+	return chirps, err
+}
+
+// TestTimelineCachePushDuringBuild posts a chirp while a cached timeline is
+// being built, after the build read the store, and checks the chirp is cached
+func TestTimelineCachePushDuringBuild(t *testing.T) {
+	db := newTestDB(t)
+	author, err := db.CreateUser("author@example.com", "pw", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := db.CreateUser("reader@example.com", "pw", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Follow(reader.ID, author.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp(NewChirp{AuthorID: author.ID, Body: "before"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &pausedStore{Store: db, paused: make(chan struct{}), resume: make(chan struct{})}
+	c := NewTimelineCache(s, 10, 50, 10)
+	built := make(chan error)
+	go func() {
+		_, _, err := c.cached(reader.ID)
+		built <- err
+	}()
+
+	<-s.paused
+	chirp, err := c.CreateChirp(NewChirp{AuthorID: author.ID, Body: "during"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(s.resume)
+	if err := <-built; err != nil {
+		t.Fatal(err)
+	}
+
+	ids, _, err := c.cached(reader.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[1] != chirp.ID {
+		t.Fatalf("cached %v, want the chirp posted during the build last", ids)
+	}
+}
@@ -10,20 +10,15 @@ type RevokedToken struct {
 }
 
 func (db *DB) RevokeToken(tokenID string, revokedAt time.Time) (RevokedToken,error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return RevokedToken{},err
-	}
-	
 	token := RevokedToken{
 		ID: tokenID,
 		RevokedAt: revokedAt,
 	}
 
-	id := len(dbStructure.Tokens) + 1
-	dbStructure.Tokens[id] = token
-
-	if err := db.writeDB(dbStructure); err != nil {
+	err := db.Update(func(tx *Tx) error {
+		return tx.log(logEntry{Op: opTokenRevoked, ID: tx.nextID(seqTokens), Token: &token})
+	})
+	if err != nil {
 		return RevokedToken{}, err
 	}
 
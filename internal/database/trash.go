@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// TrashRetention is how long deleted chirps stay in the trash before they are purged
+const TrashRetention = 30 * 24 * time.Hour
+
+// trashed reports whether the chirp is in the trash, hidden but restorable
+func (chirp Chirp) trashed() bool {
+	return chirp.Deleted && chirp.DeletedAt != nil
+}
+
+// GetTrash returns a page of the chirps authorID has in the trash, ordered by id
+func (db *DB) GetTrash(authorID int, page Page) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	ids := page.window(db.index.trash[authorID])
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		chirps = append(chirps, db.withQuote(db.data.Chirps[id]))
+	}
+	return chirps, nil
+}
+
+// RestoreChirp takes a chirp owned by authorID back out of the trash
+func (db *DB) RestoreChirp(authorID, id int) (Chirp, error) {
+	var chirp Chirp
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		chirp, ok = tx.Chirp(id)
+		if !ok || !chirp.trashed() || chirp.AuthorID != authorID {
+			return ErrChirpNotFound
+		}
+
+		chirp.Deleted = false
+		chirp.DeletedAt = nil
+		return tx.log(logEntry{Op: opChirpUpdated, Chirp: &chirp})
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	return db.view(chirp), nil
+}
+
+// PurgeTrash permanently deletes the chirps put in the trash before the given
+// time and returns how many there were
+func (db *DB) PurgeTrash(before time.Time) (int, error) {
+	var purged int
+	err := db.Update(func(tx *Tx) error {
+		var ids []int
+		for _, trash := range tx.db.index.trash {
+			for _, id := range trash {
+				if tx.db.data.Chirps[id].DeletedAt.Before(before) {
+					ids = append(ids, id)
+				}
+			}
+		}
+		sort.Ints(ids)
+
+		for _, id := range ids {
+			if err := tx.purgeChirp(id); err != nil {
+				return err
+			}
+		}
+		purged = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// purgeChirp permanently deletes a chirp in the trash with its reactions and
+// revisions. A chirp with replies is replaced by its tombstone so the thread
+// stays whole; tombstones left with no replies are removed.
+func (tx *Tx) purgeChirp(id int) error {
+	chirp, ok := tx.Chirp(id)
+	if !ok || !chirp.trashed() {
+		return errors.New("purging a chirp that is not in the trash")
+	}
+
+	if err := tx.deleteReactions(id); err != nil {
+		return err
+	}
+	if err := tx.deleteRevisions(id); err != nil {
+		return err
+	}
+	if len(tx.db.index.replies[id]) > 0 {
+		tombstone := chirp.tombstone()
+		return tx.log(logEntry{Op: opChirpUpdated, Chirp: &tombstone})
+	}
+
+	for {
+		if err := tx.log(logEntry{Op: opChirpDeleted, ID: chirp.ID}); err != nil {
+			return err
+		}
+		parent, ok := tx.Chirp(chirp.InReplyTo)
+		if !ok || !parent.Deleted || parent.trashed() || len(tx.db.index.replies[parent.ID]) > 0 {
+			return nil
+		}
+		chirp = parent
+	}
+}
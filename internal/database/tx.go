@@ -0,0 +1,185 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// errReadOnlyTx is returned when a View transaction tries to write
+	errReadOnlyTx = errors.New("cannot write in a read-only transaction")
+	// errReadOnlyDB is returned by Update on a database opened read-only
+	errReadOnlyDB = errors.New("database is open read-only")
+)
+
+// Tx is a transaction over the in-memory dataset. Writes are applied straight
+// away so later reads in the same transaction see them; they are appended to
+// the operation log together when the transaction commits, and undone if it
+// fails.
+type Tx struct {
+	db       *DB
+	writable bool
+
+	entries []logEntry
+	// undo holds the inverse of each logged entry, in the order they were logged
+	undo      [][]logEntry
+	sequences map[string]int
+}
+
+// View runs fn with the read lock held. fn must not write.
+func (db *DB) View(fn func(tx *Tx) error) error {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return fn(&Tx{db: db})
+}
+
+// Update runs fn with the write lock held, so nothing else reads or writes the
+// database while it runs. If fn returns an error, or the log can't be
+// written, every change it made is rolled back.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	if db.readOnly {
+		return errReadOnlyDB
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	tx := &Tx{
+		db:        db,
+		writable:  true,
+		sequences: make(map[string]int, len(db.data.Sequences)),
+	}
+	for k, v := range db.data.Sequences {
+		tx.sequences[k] = v
+	}
+
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+// Chirp returns the chirp with the given id
+func (tx *Tx) Chirp(id int) (Chirp, bool) {
+	chirp, ok := tx.db.data.Chirps[id]
+	return chirp, ok
+}
+
+// User returns the user with the given id
+func (tx *Tx) User(id int) (User, bool) {
+	user, ok := tx.db.data.Users[id]
+	return user, ok
+}
+
+// UserByEmail returns the user with the given email, ignoring case
+func (tx *Tx) UserByEmail(email string) (User, bool) {
+	id, ok := tx.db.index.usersByEmail[emailKey(email)]
+	if !ok {
+		return User{}, false
+	}
+	return tx.db.data.Users[id], true
+}
+
+// UserByHandle returns the user with the given handle
+func (tx *Tx) UserByHandle(handle string) (User, bool) {
+	id, ok := tx.db.index.usersByHandle[handle]
+	if !ok {
+		return User{}, false
+	}
+	return tx.db.data.Users[id], true
+}
+
+// UserByResetToken returns the user with a pending password reset whose
+// token has the given hash
+func (tx *Tx) UserByResetToken(hash string) (User, bool) {
+	id, ok := tx.db.index.usersByReset[hash]
+	if !ok {
+		return User{}, false
+	}
+	return tx.db.data.Users[id], true
+}
+
+// nextID returns the id for a new record of entity
+func (tx *Tx) nextID(entity string) int {
+	return tx.db.nextID(entity)
+}
+
+// log applies e to the dataset and queues it for the operation log
+func (tx *Tx) log(e logEntry) error {
+	if !tx.writable {
+		return errReadOnlyTx
+	}
+
+	inverse := tx.db.inverse(e)
+	if err := tx.db.apply(e); err != nil {
+		return err
+	}
+	tx.entries = append(tx.entries, e)
+	tx.undo = append(tx.undo, inverse)
+	return nil
+}
+
+// commit writes the queued entries to the operation log as a single line, so
+// a crash replays either all of the transaction or none of it
+func (tx *Tx) commit() error {
+	if len(tx.entries) == 0 {
+		return nil
+	}
+	db := tx.db
+
+	e := tx.entries[0]
+	if len(tx.entries) > 1 {
+		e = logEntry{Op: opBatch, Batch: tx.entries}
+	}
+	e.Seq = db.seq + 1
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line, err = db.env.encodeLine(line)
+	if err != nil {
+		return err
+	}
+
+	// Cut a partial write back off so the next entry doesn't land after a
+	// torn line. The file offset of an O_APPEND file only moves on write, so
+	// the end of the log comes from its size.
+	info, err := db.wal.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	if _, err := db.wal.Write(append(line, '\n')); err != nil {
+		db.wal.Truncate(end)
+		return err
+	}
+	if err := db.wal.Sync(); err != nil {
+		db.wal.Truncate(end)
+		return err
+	}
+
+	db.seq = e.Seq
+	db.logged++
+	return nil
+}
+
+// rollback undoes every applied entry, newest first
+func (tx *Tx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		for _, e := range tx.undo[i] {
+			tx.db.apply(e)
+		}
+	}
+	if tx.sequences != nil {
+		tx.db.data.Sequences = tx.sequences
+	}
+	tx.entries = nil
+	tx.undo = nil
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newTestDB opens a JSON database in a temporary directory, closed when the
+// test ends
+func newTestDB(t testing.TB) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestConcurrentCreates hammers the database with concurrent creates and
+// updates, which lost writes before mutations ran in a transaction. Run it
+// with -race.
+func TestConcurrentCreates(t *testing.T) {
+	const writers, perWriter = 8, 50
+
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("a@example.com", "pw", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter*2)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if _, err := db.CreateChirp(NewChirp{AuthorID: user.ID, Body: fmt.Sprintf("chirp %d from writer %d", i, w)}); err != nil {
+					errs <- err
+				}
+				if _, err := db.UpdateMembership(user.ID, i%2 == 0); err != nil {
+					errs <- err
+				}
+				if _, err := db.GetChirps(Page{Limit: 10, Desc: true}); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	checkChirps := func(db *DB) {
+		t.Helper()
+		chirps, err := db.GetChirps(Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chirps) != writers*perWriter {
+			t.Fatalf("got %d chirps, want %d", len(chirps), writers*perWriter)
+		}
+		seen := make(map[int]bool, len(chirps))
+		for _, chirp := range chirps {
+			if seen[chirp.ID] {
+				t.Fatalf("chirp id %d handed out twice", chirp.ID)
+			}
+			seen[chirp.ID] = true
+		}
+	}
+	checkChirps(db)
+
+	// Every create must also have reached the log
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDB(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkChirps(db)
+}
+
+// TestUpdateRollsBack checks a failed transaction leaves no trace, not even
+// a used up id
+func TestUpdateRollsBack(t *testing.T) {
+	db := newTestDB(t)
+	user, err := db.CreateUser("a@example.com", "pw", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errFail := errors.New("fail")
+	err = db.Update(func(tx *Tx) error {
+		if _, err := tx.createChirp(NewChirp{AuthorID: user.ID, Body: "rolled back"}); err != nil {
+			return err
+		}
+		return errFail
+	})
+	if !errors.Is(err, errFail) {
+		t.Fatalf("Update returned %v, want %v", err, errFail)
+	}
+
+	chirps, err := db.GetChirps(Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 0 {
+		t.Fatalf("got %d chirps after rollback, want 0", len(chirps))
+	}
+	chirp, err := db.CreateChirp(NewChirp{AuthorID: user.ID, Body: "kept"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.ID != 1 {
+		t.Fatalf("chirp after rollback got id %d, want 1", chirp.ID)
+	}
+}
@@ -3,139 +3,202 @@ package database
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/cloudflare/cfssl/log"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrUserNotFound is returned when no user has the requested id, email or handle
+var ErrUserNotFound = errors.New("User not found")
+
 type User struct {
 	ID       int    `json:"id"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	Membership bool `json:"is_chirpy_red"`
+	Handle string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	// AvatarID is the id of the image shown as the user's avatar, 0 for none
+	AvatarID  int       `json:"avatar_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	EmailVerified bool `json:"email_verified"`
+	// VerificationID is the id of the one token that can verify the email,
+	// empty once it has been used, and VerificationSentAt when it was sent
+	VerificationID     string     `json:"verification_id,omitempty"`
+	VerificationSentAt *time.Time `json:"verification_sent_at,omitempty"`
+	// ResetTokenHash is the hash of the one token that can reset the
+	// password, empty once it has been used, and ResetSentAt when it was sent
+	ResetTokenHash string     `json:"reset_token_hash,omitempty"`
+	ResetSentAt    *time.Time `json:"reset_sent_at,omitempty"`
+	// TokensValidAfter is when the password was last reset. Refresh tokens
+	// issued before then no longer work.
+	TokensValidAfter *time.Time `json:"tokens_valid_after,omitempty"`
 }
 
-func (db *DB) CreateUser(email, password string) (User, error) {
-
-	dbStructure, err := db.loadDB()
+// CreateUser creates a user, whose email starts out unverified. An empty
+// handle is derived from the email.
+func (db *DB) CreateUser(email, password, handle string) (User, error) {
+	email, err := NormalizeEmail(email)
 	if err != nil {
 		return User{}, err
 	}
-
-	// Check if user with the same email already exists
-	for _, user := range dbStructure.Users {
-		if user.Email == email {
-			return User{}, fmt.Errorf("user with email %s already exists", email)
+	if handle != "" {
+		var err error
+		if handle, err = NormalizeHandle(handle); err != nil {
+			return User{}, err
 		}
 	}
 
-	id := len(dbStructure.Users) + 1
-
+	// Hash before taking the lock, bcrypt is deliberately slow
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return User{}, err
 	}
 	// fmt.Printf("hashed password in CreateUser: %s", hashedPassword)
 
-	user := User{
-		ID:       id,
-		Email:    email,
-		Password: string(hashedPassword),
-		Membership: false,
-	}
+	var user User
+	err = db.Update(func(tx *Tx) error {
+		// Check if user with the same email already exists
+		if _, ok := tx.UserByEmail(email); ok {
+			return fmt.Errorf("user with email %s already exists", email)
+		}
 
-	dbStructure.Users[id] = user
+		if handle == "" {
+			handle = handleFromEmail(email, func(h string) bool {
+				_, taken := tx.UserByHandle(h)
+				return taken
+			})
+		} else if _, ok := tx.UserByHandle(handle); ok {
+			return fmt.Errorf("user with handle %s already exists", handle)
+		}
 
-	if err := db.writeDB(dbStructure); err != nil {
+		user = User{
+			ID:       tx.nextID(seqUsers),
+			Email:    email,
+			Password: string(hashedPassword),
+			Membership: false,
+			Handle:   handle,
+			CreatedAt: time.Now().UTC(),
+		}
+		return tx.log(logEntry{Op: opUserCreated, User: &user})
+	})
+	if err != nil {
 		return User{}, err
 	}
 
 	return user, nil
 }
 
+// GetUserbyEmail returns the user with the given email, ignoring case and
+// surrounding spaces
 func (db *DB) GetUserbyEmail(email string) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
+	id, ok := db.index.usersByEmail[emailKey(email)]
+	if !ok {
+		return User{}, ErrUserNotFound
 	}
-
-	for _, user := range dbStructure.Users {
-		if user.Email == email {
-			return user, nil
-		}
-	}
-	return User{}, errors.New("User not found")
+	return db.data.Users[id], nil
 }
 
-func (db *DB) UpdateUser(userID int, email, password string) (User, error) {
-	// Load the current JSON data from the database file
-	user, err := db.GetUser(userID)
-	if err != nil {
-		log.Error(err)
-	}
-
-	index := user.ID
-	dbStructure, err := db.loadDB()
+// UpdateUser sets a new email and password for the user, and a new handle
+// unless handle is empty. A changed email has to be verified again.
+func (db *DB) UpdateUser(userID int, email, password, handle string) (User, error) {
+	email, err := NormalizeEmail(email)
 	if err != nil {
 		return User{}, err
 	}
-	
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if handle != "" {
+		var err error
+		if handle, err = NormalizeHandle(handle); err != nil {
+			return User{}, err
+		}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return User{}, err
 	}
 
-	// Replace the user at that index with the updated user
-	user.Email = email
-	user.Password = string(hashedPassword)
-	dbStructure.Users[index] = user
+	var user User
+	err = db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userID)
+		if !ok {
+			return ErrUserNotFound
+		}
 
+		if other, ok := tx.UserByEmail(email); ok && other.ID != userID {
+			return fmt.Errorf("user with email %s already exists", email)
+		}
+
+		if handle != "" {
+			if other, ok := tx.UserByHandle(handle); ok && other.ID != userID {
+				return fmt.Errorf("user with handle %s already exists", handle)
+			}
+			user.Handle = handle
+		}
 
-	err = db.writeDB(dbStructure)
+		if email != emailKey(user.Email) {
+			user.EmailVerified = false
+			user.VerificationID = ""
+			user.ResetTokenHash = ""
+		}
+		// Replace the user at that index with the updated user
+		user.Email = email
+		user.Password = string(hashedPassword)
+		return tx.log(logEntry{Op: opUserUpdated, User: &user})
+	})
 	if err != nil {
 		log.Error(err)
+		return User{}, err
 	}
 
 	return user, nil
 }
 
 func (db *DB) GetUser(userID int) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
-	for _, user := range dbStructure.Users {
-		if user.ID == userID {
-			return user, nil
-		}
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return User{}, ErrUserNotFound
 	}
-	return User{}, errors.New("User not found")
+	return user, nil
 }
 
 func (db *DB) UpdateMembership(userID int, membership bool) (User, error) {
-	// Load the current JSON data from the database file
-	user, err := db.GetUser(userID)
-	if err != nil {
-		log.Error(err)
-	}
-
-	index := user.ID
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	user.Membership = membership
-	dbStructure.Users[index] = user
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userID)
+		if !ok {
+			return ErrUserNotFound
+		}
 
-	err = db.writeDB(dbStructure)
+		user.Membership = membership
+		return tx.log(logEntry{Op: opUserUpdated, User: &user})
+	})
 	if err != nil {
 		log.Error(err)
+		return User{}, err
 	}
 
 	return user, nil
 }
 
+// GetUserByHandle returns the user with the given handle
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
+	id, ok := db.index.usersByHandle[handle]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return db.data.Users[id], nil
+}
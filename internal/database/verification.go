@@ -0,0 +1,107 @@
+package database
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidEmail is returned for an email that isn't a plain address
+	ErrInvalidEmail = errors.New("email must be a valid address")
+	// ErrAlreadyVerified is returned when asking to verify a verified email
+	ErrAlreadyVerified = errors.New("email is already verified")
+	// ErrVerificationTooSoon is returned when a verification email was sent
+	// too recently to send another
+	ErrVerificationTooSoon = errors.New("verification email sent too recently")
+	// ErrInvalidVerification is returned for a verification token that isn't
+	// the user's latest, has been used, or was sent to an email they no
+	// longer have
+	ErrInvalidVerification = errors.New("invalid or expired verification token")
+)
+
+// NormalizeEmail trims and lowercases the email and checks it is a bare
+// address, with no display name
+func NormalizeEmail(email string) (string, error) {
+	email = emailKey(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+// emailKey is what users are looked up by email with. Emails stored before
+// they were normalized may not be lowercase.
+func emailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// startVerification checks a verification email can be sent to the user
+// and records tokenID as the only token that can verify them
+func (user *User) startVerification(tokenID string, now time.Time, minInterval time.Duration) error {
+	if user.EmailVerified {
+		return ErrAlreadyVerified
+	}
+	if user.VerificationSentAt != nil && now.Sub(*user.VerificationSentAt) < minInterval {
+		return ErrVerificationTooSoon
+	}
+	user.VerificationID = tokenID
+	user.VerificationSentAt = &now
+	return nil
+}
+
+// verify marks the user's email verified if tokenID is their pending
+// verification for email, using it up
+func (user *User) verify(tokenID, email string) error {
+	if user.VerificationID == "" || user.VerificationID != tokenID || user.Email != email {
+		return ErrInvalidVerification
+	}
+	user.EmailVerified = true
+	user.VerificationID = ""
+	return nil
+}
+
+// StartEmailVerification records tokenID as the token that verifies the
+// user's email, replacing any sent before, unless the last one was sent less
+// than minInterval before now
+func (db *DB) StartEmailVerification(userID int, tokenID string, now time.Time, minInterval time.Duration) (User, error) {
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userID)
+		if !ok {
+			return ErrUserNotFound
+		}
+		if err := user.startVerification(tokenID, now, minInterval); err != nil {
+			return err
+		}
+		return tx.log(logEntry{Op: opUserUpdated, User: &user})
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// VerifyEmail marks the user's email verified if tokenID is the latest
+// verification token sent to them, at email
+func (db *DB) VerifyEmail(userID int, tokenID, email string) (User, error) {
+	var user User
+	err := db.Update(func(tx *Tx) error {
+		var ok bool
+		user, ok = tx.User(userID)
+		if !ok {
+			return ErrUserNotFound
+		}
+		if err := user.verify(tokenID, email); err != nil {
+			return err
+		}
+		return tx.log(logEntry{Op: opUserUpdated, User: &user})
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
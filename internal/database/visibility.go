@@ -0,0 +1,211 @@
+package database
+
+// viewerStore is a Store as one user sees it. It hides the chirps of users
+// the viewer blocked or was blocked by, and leaves the chirps of users they
+// muted out of their timeline and search results.
+type viewerStore struct {
+	Store
+	hidden map[int]bool
+	muted  map[int]bool
+}
+
+// ForViewer returns s as the user viewerID sees it when reading chirps.
+// Pages of chirps are read on until they are full, so hidden chirps don't
+// shorten them. A viewerID of 0, for a reader who isn't signed in, sees
+// everything.
+func ForViewer(s Store, viewerID int) (Store, error) {
+	if viewerID == 0 {
+		return s, nil
+	}
+	restrictions, err := s.GetRestrictions(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(restrictions.Blocked)+len(restrictions.BlockedBy)+len(restrictions.Muted) == 0 {
+		return s, nil
+	}
+
+	v := &viewerStore{Store: s, hidden: make(map[int]bool), muted: make(map[int]bool)}
+	for _, ids := range [][]int{restrictions.Blocked, restrictions.BlockedBy} {
+		for _, id := range ids {
+			v.hidden[id] = true
+		}
+	}
+	for _, id := range restrictions.Muted {
+		v.muted[id] = true
+	}
+	return v, nil
+}
+
+// fill reads pages with read until it has page.Limit items that keep
+// reports true for, or the list runs out
+func fill[T any](page Page, read func(Page) ([]T, error), id func(T) int, keep func(T) bool) ([]T, error) {
+	out := []T{}
+	for {
+		items, err := read(page)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if !keep(item) {
+				continue
+			}
+			out = append(out, item)
+			if page.Limit != 0 && len(out) == page.Limit {
+				return out, nil
+			}
+		}
+		if page.Limit == 0 || len(items) < page.Limit {
+			return out, nil
+		}
+		page.After = id(items[len(items)-1])
+	}
+}
+
+func chirpID(c Chirp) int { return c.ID }
+
+// shows reports whether the viewer may see the chirp
+func (v *viewerStore) shows(c Chirp) bool {
+	return !v.hidden[c.AuthorID]
+}
+
+// inFeed reports whether the chirp belongs in the viewer's timeline and searches
+func (v *viewerStore) inFeed(c Chirp) bool {
+	return v.shows(c) && !v.muted[c.AuthorID]
+}
+
+// seen returns the chirp with its quote marked unavailable if the viewer
+// may not see the quoted chirp
+func (v *viewerStore) seen(c Chirp) Chirp {
+	if c.Quoted != nil && !c.Quoted.Unavailable && v.hidden[c.Quoted.AuthorID] {
+		c.Quoted = &QuotedChirp{ID: c.Quoted.ID, Unavailable: true}
+	}
+	return c
+}
+
+// chirps reads a full page of the chirps keep reports true for
+func (v *viewerStore) chirps(page Page, read func(Page) ([]Chirp, error), keep func(Chirp) bool) ([]Chirp, error) {
+	chirps, err := fill(page, read, chirpID, keep)
+	if err != nil {
+		return nil, err
+	}
+	for i := range chirps {
+		chirps[i] = v.seen(chirps[i])
+	}
+	return chirps, nil
+}
+
+func (v *viewerStore) GetChirps(page Page) ([]Chirp, error) {
+	return v.chirps(page, v.Store.GetChirps, v.shows)
+}
+
+// GetChirp returns ErrChirpNotFound for a chirp the viewer may not see
+func (v *viewerStore) GetChirp(id int) (Chirp, error) {
+	chirp, err := v.Store.GetChirp(id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if !v.shows(chirp) {
+		return Chirp{}, ErrChirpNotFound
+	}
+	return v.seen(chirp), nil
+}
+
+func (v *viewerStore) GetChirpsByAuthor(authorID int, page Page) ([]Chirp, error) {
+	if v.hidden[authorID] {
+		return []Chirp{}, nil
+	}
+	return v.chirps(page, func(page Page) ([]Chirp, error) {
+		return v.Store.GetChirpsByAuthor(authorID, page)
+	}, v.shows)
+}
+
+// SearchChirps leaves hidden and muted authors out of the search itself,
+// so the ranked windows it returns stay consecutive
+func (v *viewerStore) SearchChirps(q SearchQuery) ([]Chirp, error) {
+	q.ExcludeAuthors = append([]int(nil), q.ExcludeAuthors...)
+	for _, ids := range []map[int]bool{v.hidden, v.muted} {
+		for id := range ids {
+			q.ExcludeAuthors = append(q.ExcludeAuthors, id)
+		}
+	}
+	chirps, err := v.Store.SearchChirps(q)
+	if err != nil {
+		return nil, err
+	}
+	for i := range chirps {
+		chirps[i] = v.seen(chirps[i])
+	}
+	return chirps, nil
+}
+
+func (v *viewerStore) GetChirpsByTag(tag string, page Page) ([]Chirp, error) {
+	return v.chirps(page, func(page Page) ([]Chirp, error) {
+		return v.Store.GetChirpsByTag(tag, page)
+	}, v.shows)
+}
+
+func (v *viewerStore) GetMentions(userID int, page Page) ([]Chirp, error) {
+	return v.chirps(page, func(page Page) ([]Chirp, error) {
+		return v.Store.GetMentions(userID, page)
+	}, v.shows)
+}
+
+func (v *viewerStore) GetTimeline(userID int, page Page) ([]Chirp, error) {
+	return v.chirps(page, func(page Page) ([]Chirp, error) {
+		return v.Store.GetTimeline(userID, page)
+	}, v.inFeed)
+}
+
+func (v *viewerStore) GetLikes(userID int, page Page) ([]LikedChirp, error) {
+	likes, err := fill(page, func(page Page) ([]LikedChirp, error) {
+		return v.Store.GetLikes(userID, page)
+	}, func(l LikedChirp) int { return l.LikeID }, func(l LikedChirp) bool { return v.shows(l.Chirp) })
+	if err != nil {
+		return nil, err
+	}
+	for i := range likes {
+		likes[i].Chirp = v.seen(likes[i].Chirp)
+	}
+	return likes, nil
+}
+
+// GetThread returns ErrChirpNotFound for a chirp the viewer may not see, and
+// shows the hidden chirps around it as tombstones, like deleted ones
+func (v *viewerStore) GetThread(id int, page Page, depth int) (Thread, error) {
+	thread, err := v.Store.GetThread(id, page, depth)
+	if err != nil {
+		return Thread{}, err
+	}
+	if !v.shows(thread.Chirp) {
+		return Thread{}, ErrChirpNotFound
+	}
+	thread.Chirp = v.seen(thread.Chirp)
+	for i, chirp := range thread.Ancestors {
+		thread.Ancestors[i] = v.threadChirp(chirp)
+	}
+	v.threadReplies(thread.Replies)
+	return thread, nil
+}
+
+func (v *viewerStore) threadChirp(chirp Chirp) Chirp {
+	if !v.shows(chirp) {
+		return chirp.tombstone()
+	}
+	return v.seen(chirp)
+}
+
+func (v *viewerStore) threadReplies(nodes []ThreadNode) {
+	for i := range nodes {
+		nodes[i].Chirp = v.threadChirp(nodes[i].Chirp)
+		v.threadReplies(nodes[i].Replies)
+	}
+}
+
+// GetRevisions returns ErrChirpNotFound for a chirp the viewer may not see
+func (v *viewerStore) GetRevisions(chirpID int, page Page) ([]Revision, error) {
+	if _, err := v.GetChirp(chirpID); err != nil {
+		return nil, err
+	}
+	return v.Store.GetRevisions(chirpID, page)
+}
@@ -0,0 +1,416 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// compactInterval is how often the background job folds the operation log into a new snapshot
+const compactInterval = time.Minute
+
+// Operations recorded in the log
+const (
+	opChirpCreated = "chirp_created"
+	opChirpUpdated = "chirp_updated"
+	opChirpDeleted = "chirp_deleted"
+	opUserCreated  = "user_created"
+	opUserUpdated  = "user_updated"
+	opUserDeleted  = "user_deleted"
+	opTokenRevoked = "token_revoked"
+	opTokenDeleted = "token_deleted"
+
+	opLikeCreated    = "like_created"
+	opLikeDeleted    = "like_deleted"
+	opRechirpCreated = "rechirp_created"
+	opRechirpDeleted = "rechirp_deleted"
+
+	opRevisionCreated = "revision_created"
+	opRevisionDeleted = "revision_deleted"
+
+	opDraftCreated = "draft_created"
+	opDraftUpdated = "draft_updated"
+	opDraftDeleted = "draft_deleted"
+
+	opMediaCreated = "media_created"
+	opMediaDeleted = "media_deleted"
+
+	opFollowCreated = "follow_created"
+	opFollowDeleted = "follow_deleted"
+
+	opRestrictionCreated = "restriction_created"
+	opRestrictionDeleted = "restriction_deleted"
+
+	// opBatch holds the operations of a transaction that made more than one change
+	opBatch = "batch"
+)
+
+// logEntry is a single line of the operation log
+type logEntry struct {
+	Seq         uint64        `json:"seq,omitempty"`
+	Op          string        `json:"op"`
+	ID          int           `json:"id,omitempty"`
+	Chirp       *Chirp        `json:"chirp,omitempty"`
+	User        *User         `json:"user,omitempty"`
+	Token       *RevokedToken `json:"token,omitempty"`
+	Reaction    *Reaction     `json:"reaction,omitempty"`
+	Revision    *Revision     `json:"revision,omitempty"`
+	Draft       *Draft        `json:"draft,omitempty"`
+	Media       *Media        `json:"media,omitempty"`
+	Follow      *Follow       `json:"follow,omitempty"`
+	Restriction *Restriction  `json:"restriction,omitempty"`
+	Batch       []logEntry    `json:"batch,omitempty"`
+}
+
+func (db *DB) walPath() string {
+	return db.path + ".wal"
+}
+
+func (db *DB) oldWALPath() string {
+	return db.path + ".wal.old"
+}
+
+// apply applies a logged operation to the in-memory dataset
+func (data *DBStructure) apply(e logEntry) error {
+	switch e.Op {
+	case opChirpCreated:
+		data.Chirps[e.Chirp.ID] = *e.Chirp
+		data.bumpSequence(seqChirps, e.Chirp.ID)
+	case opChirpUpdated:
+		data.Chirps[e.Chirp.ID] = *e.Chirp
+	case opChirpDeleted:
+		delete(data.Chirps, e.ID)
+	case opUserCreated:
+		data.Users[e.User.ID] = *e.User
+		data.bumpSequence(seqUsers, e.User.ID)
+	case opUserUpdated:
+		data.Users[e.User.ID] = *e.User
+	case opUserDeleted:
+		delete(data.Users, e.ID)
+	case opTokenRevoked:
+		data.Tokens[e.ID] = *e.Token
+		data.bumpSequence(seqTokens, e.ID)
+	case opTokenDeleted:
+		delete(data.Tokens, e.ID)
+	case opLikeCreated, opRechirpCreated:
+		k := reactionKindOf(e.Op)
+		data.reactions(k)[e.Reaction.ID] = *e.Reaction
+		data.bumpSequence(k.entity, e.Reaction.ID)
+	case opLikeDeleted, opRechirpDeleted:
+		delete(data.reactions(reactionKindOf(e.Op)), e.ID)
+	case opRevisionCreated:
+		data.Revisions[e.Revision.ID] = *e.Revision
+		data.bumpSequence(seqRevisions, e.Revision.ID)
+	case opRevisionDeleted:
+		delete(data.Revisions, e.ID)
+	case opDraftCreated:
+		data.Drafts[e.Draft.ID] = *e.Draft
+		data.bumpSequence(seqDrafts, e.Draft.ID)
+	case opDraftUpdated:
+		data.Drafts[e.Draft.ID] = *e.Draft
+	case opDraftDeleted:
+		delete(data.Drafts, e.ID)
+	case opMediaCreated:
+		data.Media[e.Media.ID] = *e.Media
+		data.bumpSequence(seqMedia, e.Media.ID)
+	case opMediaDeleted:
+		delete(data.Media, e.ID)
+	case opFollowCreated:
+		data.Follows[e.Follow.ID] = *e.Follow
+		data.bumpSequence(seqFollows, e.Follow.ID)
+	case opFollowDeleted:
+		delete(data.Follows, e.ID)
+	case opRestrictionCreated:
+		data.Restrictions[e.Restriction.ID] = *e.Restriction
+		data.bumpSequence(seqRestrictions, e.Restriction.ID)
+	case opRestrictionDeleted:
+		delete(data.Restrictions, e.ID)
+	default:
+		return fmt.Errorf("unknown operation %q in log", e.Op)
+	}
+	return nil
+}
+
+// inverse returns the entries that undo e against the current dataset. It
+// must be called before e is applied.
+func (db *DB) inverse(e logEntry) []logEntry {
+	switch e.Op {
+	case opBatch:
+		var undo []logEntry
+		for i := len(e.Batch) - 1; i >= 0; i-- {
+			undo = append(undo, db.inverse(e.Batch[i])...)
+		}
+		return undo
+	case opChirpCreated, opChirpUpdated, opChirpDeleted:
+		id := e.ID
+		if e.Chirp != nil {
+			id = e.Chirp.ID
+		}
+		if old, ok := db.data.Chirps[id]; ok {
+			return []logEntry{{Op: opChirpUpdated, Chirp: &old}}
+		}
+		if e.Op != opChirpDeleted {
+			return []logEntry{{Op: opChirpDeleted, ID: id}}
+		}
+	case opUserCreated, opUserUpdated, opUserDeleted:
+		id := e.ID
+		if e.User != nil {
+			id = e.User.ID
+		}
+		if old, ok := db.data.Users[id]; ok {
+			return []logEntry{{Op: opUserUpdated, User: &old}}
+		}
+		if e.Op != opUserDeleted {
+			return []logEntry{{Op: opUserDeleted, ID: id}}
+		}
+	case opTokenRevoked, opTokenDeleted:
+		if old, ok := db.data.Tokens[e.ID]; ok {
+			return []logEntry{{Op: opTokenRevoked, ID: e.ID, Token: &old}}
+		}
+		if e.Op == opTokenRevoked {
+			return []logEntry{{Op: opTokenDeleted, ID: e.ID}}
+		}
+	case opLikeCreated, opLikeDeleted, opRechirpCreated, opRechirpDeleted:
+		k := reactionKindOf(e.Op)
+		id := e.ID
+		if e.Reaction != nil {
+			id = e.Reaction.ID
+		}
+		if old, ok := db.data.reactions(k)[id]; ok {
+			return []logEntry{{Op: k.created, Reaction: &old}}
+		}
+		if e.Op == k.created {
+			return []logEntry{{Op: k.deleted, ID: id}}
+		}
+	case opRevisionCreated, opRevisionDeleted:
+		id := e.ID
+		if e.Revision != nil {
+			id = e.Revision.ID
+		}
+		if old, ok := db.data.Revisions[id]; ok {
+			return []logEntry{{Op: opRevisionCreated, Revision: &old}}
+		}
+		if e.Op == opRevisionCreated {
+			return []logEntry{{Op: opRevisionDeleted, ID: id}}
+		}
+	case opDraftCreated, opDraftUpdated, opDraftDeleted:
+		id := e.ID
+		if e.Draft != nil {
+			id = e.Draft.ID
+		}
+		if old, ok := db.data.Drafts[id]; ok {
+			return []logEntry{{Op: opDraftUpdated, Draft: &old}}
+		}
+		if e.Op != opDraftDeleted {
+			return []logEntry{{Op: opDraftDeleted, ID: id}}
+		}
+	case opMediaCreated:
+		return []logEntry{{Op: opMediaDeleted, ID: e.Media.ID}}
+	case opFollowCreated:
+		return []logEntry{{Op: opFollowDeleted, ID: e.Follow.ID}}
+	case opFollowDeleted:
+		if old, ok := db.data.Follows[e.ID]; ok {
+			return []logEntry{{Op: opFollowCreated, Follow: &old}}
+		}
+	case opRestrictionCreated:
+		return []logEntry{{Op: opRestrictionDeleted, ID: e.Restriction.ID}}
+	case opRestrictionDeleted:
+		if old, ok := db.data.Restrictions[e.ID]; ok {
+			return []logEntry{{Op: opRestrictionCreated, Restriction: &old}}
+		}
+	}
+	return nil
+}
+
+// replay applies every entry in the log at path that is newer than the
+// snapshot. A torn final line, left by a crash mid-append, is truncated.
+func (db *DB) replay(path string) error {
+	flag := os.O_RDWR
+	if db.readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0666)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 && !db.readOnly {
+				log.Printf("database: truncating incomplete entry at end of %s", path)
+				return file.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var e logEntry
+		plain, err := db.env.decodeLine(bytes.TrimSpace(line))
+		if err != nil {
+			return fmt.Errorf("entry in %s at offset %d: %w", path, offset, err)
+		}
+		if err := json.Unmarshal(plain, &e); err != nil {
+			return fmt.Errorf("corrupt entry in %s at offset %d: %w", path, offset, err)
+		}
+		offset += int64(len(line))
+
+		if e.Seq <= db.seq {
+			continue
+		}
+		if err := db.apply(e); err != nil {
+			return err
+		}
+		db.seq = e.Seq
+		db.logged++
+	}
+}
+
+// compactLoop periodically snapshots the dataset until the database is closed
+func (db *DB) compactLoop() {
+	defer db.wg.Done()
+
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-ticker.C:
+			if err := db.compact(); err != nil {
+				log.Printf("database: snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// compact writes the dataset to a new snapshot and discards the log entries
+// it contains. Writers are only blocked while the dataset is copied and the
+// log is rotated, not while the snapshot is encoded and written.
+func (db *DB) compact() error {
+	return db.snapshot(false)
+}
+
+// snapshot writes the dataset to a new snapshot and discards the log, even
+// if nothing was logged since the last one when force is set
+func (db *DB) snapshot(force bool) error {
+	db.compactMux.Lock()
+	defer db.compactMux.Unlock()
+
+	db.mux.Lock()
+	if db.logged == 0 && !force {
+		db.mux.Unlock()
+		return nil
+	}
+	snapshot := db.data.clone()
+	snapshot.LogSequence = db.seq
+
+	if err := db.rotateWAL(); err != nil {
+		db.mux.Unlock()
+		return err
+	}
+	db.logged = 0
+	db.mux.Unlock()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	data, err = db.env.encodeFile(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(db.path, data); err != nil {
+		return err
+	}
+
+	return os.Remove(db.oldWALPath())
+}
+
+// rotateWAL moves the log aside so entries up to the snapshot being written
+// stay on disk until it is complete, and starts a fresh log for new entries.
+// If an earlier snapshot failed the old log is still there and is kept as is;
+// the current log is left in place and its entries are skipped on replay once
+// the snapshot covers them. The caller must hold the write lock.
+func (db *DB) rotateWAL() error {
+	if _, err := os.Stat(db.oldWALPath()); err == nil {
+		return nil
+	}
+
+	if err := db.wal.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(db.walPath(), db.oldWALPath())
+
+	wal, err := os.OpenFile(db.walPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	db.wal = wal
+	return renameErr
+}
+
+// clone returns a copy of the dataset that is safe to read without holding the lock
+func (data DBStructure) clone() DBStructure {
+	out := data
+	out.Chirps = make(map[int]Chirp, len(data.Chirps))
+	for k, v := range data.Chirps {
+		out.Chirps[k] = v
+	}
+	out.Users = make(map[int]User, len(data.Users))
+	for k, v := range data.Users {
+		out.Users[k] = v
+	}
+	out.Tokens = make(map[int]RevokedToken, len(data.Tokens))
+	for k, v := range data.Tokens {
+		out.Tokens[k] = v
+	}
+	out.Likes = make(map[int]Reaction, len(data.Likes))
+	for k, v := range data.Likes {
+		out.Likes[k] = v
+	}
+	out.Rechirps = make(map[int]Reaction, len(data.Rechirps))
+	for k, v := range data.Rechirps {
+		out.Rechirps[k] = v
+	}
+	out.Revisions = make(map[int]Revision, len(data.Revisions))
+	for k, v := range data.Revisions {
+		out.Revisions[k] = v
+	}
+	out.Drafts = make(map[int]Draft, len(data.Drafts))
+	for k, v := range data.Drafts {
+		out.Drafts[k] = v
+	}
+	out.Media = make(map[int]Media, len(data.Media))
+	for k, v := range data.Media {
+		out.Media[k] = v
+	}
+	out.Follows = make(map[int]Follow, len(data.Follows))
+	for k, v := range data.Follows {
+		out.Follows[k] = v
+	}
+	out.Restrictions = make(map[int]Restriction, len(data.Restrictions))
+	for k, v := range data.Restrictions {
+		out.Restrictions[k] = v
+	}
+	out.Sequences = make(map[string]int, len(data.Sequences))
+	for k, v := range data.Sequences {
+		out.Sequences[k] = v
+	}
+	return out
+}
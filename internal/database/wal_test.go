@@ -0,0 +1,94 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyFile copies src to dst, keeping its mode
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, data, info.Mode()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReplayTornLine opens a database whose log ends in half an entry, as a
+// crash mid-append leaves it, checking the entries before it are replayed,
+// the torn one is dropped and later entries are logged after them
+func TestReplayTornLine(t *testing.T) {
+	db := newTestDB(t)
+	user, err := db.CreateUser("a@example.com", "pw", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"first", "second"} {
+		if _, err := db.CreateChirp(NewChirp{AuthorID: user.ID, Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Copy the files as they are while the database is open, as if the
+	// server had crashed, then tear the last entry
+	path := filepath.Join(t.TempDir(), "database.json")
+	copyFile(t, db.path, path)
+	copyFile(t, db.walPath(), path+".wal")
+	wal, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wal.WriteString(`{"seq":99,"op":"chirp_cre`); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	crashed, err := NewDB(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirps, err := crashed.GetChirps(Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 2 {
+		t.Fatalf("got %d chirps after replay, want 2", len(chirps))
+	}
+	// Opening folds the log into the snapshot, so it may be empty
+	if after, err := os.ReadFile(path + ".wal"); err != nil {
+		t.Fatal(err)
+	} else if len(after) > 0 && after[len(after)-1] != '\n' {
+		t.Fatalf("log ends in a torn entry after replay: %q", after)
+	}
+
+	if _, err := crashed.CreateChirp(NewChirp{AuthorID: user.ID, Body: "third"}); err != nil {
+		t.Fatal(err)
+	}
+	// Replay the log again without the snapshot Close writes
+	copyFile(t, path, path+".copy")
+	copyFile(t, path+".wal", path+".copy.wal")
+	if err := crashed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewDB(path+".copy", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if chirps, err = reopened.GetChirps(Page{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 3 {
+		t.Fatalf("got %d chirps after reopening, want 3", len(chirps))
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+func init() {
+	// Token times are compared with the time of the user's last password
+	// reset, so whole seconds would revoke tokens issued just after one
+	jwt.TimePrecision = time.Microsecond
+}
+
+// authenticate checks the access token in the Authorization header and
+// returns the id of the user it was issued to
+func authenticate(r *http.Request, apiCfg *config.ApiConfig) (int, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return 0, errors.New("Authorization header missing")
+	}
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+
+	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(apiCfg.JwtSecret), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !token.Valid {
+		return 0, errors.New("Invalid token, please refresh after it is invalid")
+	}
+
+	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	if !ok || claims.Issuer != "chirpy-access" {
+		return 0, errors.New("Invalid token: Must be a Access Token, please refresh after it is invalid")
+	}
+	if claims.ExpiresAt == nil || claims.ExpiresAt.Before(time.Now().UTC()) {
+		return 0, errors.New("Access Token has expired")
+	}
+
+	return strconv.Atoi(claims.Subject)
+}
+
+// AsViewer serves each request with the handler h makes for db as the
+// caller sees it, through database.ForViewer: without the chirps of users
+// they blocked or who blocked them, and without those of users they muted in
+// their timeline and searches. Requests without an access token see
+// everything.
+func AsViewer(db database.Store, apiCfg *config.ApiConfig, h func(database.Store) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewerID := 0
+		if r.Header.Get("Authorization") != "" {
+			id, err := authenticate(r, apiCfg)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			viewerID = id
+		}
+
+		view, err := database.ForViewer(db, viewerID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		h(view)(w, r)
+	}
+}
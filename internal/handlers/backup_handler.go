@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+// BackupHandler streams a gzip'd snapshot of the database while the server keeps serving
+func BackupHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiCfg.AdminKey == "" {
+			http.Error(w, "Backups are disabled, set ADMIN_KEY to enable them", http.StatusForbidden)
+			return
+		}
+
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+			return
+		}
+
+		apiString := strings.TrimPrefix(authHeader, "ApiKey ")
+		if subtle.ConstantTimeCompare([]byte(apiString), []byte(apiCfg.AdminKey)) != 1 {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		filename := fmt.Sprintf("chirpy-backup-%s.gz", time.Now().UTC().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		cw := &countingWriter{w: w}
+		if err := db.Backup(cw); err != nil {
+			log.Printf("backup failed: %v", err)
+			// Once the stream has started the status can't change, the
+			// truncated backup fails its checksum on restore
+			if cw.n == 0 {
+				w.Header().Del("Content-Disposition")
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+			}
+		}
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -2,76 +2,69 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
-	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/lordmoma/chirpy/internal/config"
 	"github.com/lordmoma/chirpy/internal/database"
 )
 
-func CreateChirpsHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerFunc {
+type CreateChirpRequest struct {
+	Body string `json:"body"`
+	// InReplyTo is the id of the chirp being replied to, if any
+	InReplyTo int `json:"in_reply_to"`
+	// QuotedChirpID is the id of the chirp being quoted, if any
+	QuotedChirpID int `json:"quoted_chirp_id"`
+	// MediaIDs are the ids of images uploaded to /api/media to attach
+	MediaIDs []int `json:"media_ids"`
+	// PublishAt, if in the future, schedules the chirp instead of posting it
+	PublishAt *time.Time `json:"publish_at"`
+}
+
+func CreateChirpsHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Parse the request body
-		var chirp database.Chirp
+		var chirp CreateChirpRequest
 		if err := json.NewDecoder(r.Body).Decode(&chirp); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+		authorID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
 			return
 		}
 
-		//tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-		// Parse and validate the token
-		token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method jwt.SigningMethodHMAC
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			// Return the secret key used to sign the token
-			return []byte(apiCfg.JwtSecret), nil
-		})
-		if err != nil {
-			fmt.Printf("token error: %v", err)
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+		body, ok := cleanChirp(w, db, apiCfg, authorID, chirp.Body)
+		if !ok {
 			return
 		}
-		if !token.Valid {
-			http.Error(w, "Invalid token, please refresh after it is invalid", http.StatusUnauthorized)
+
+		newChirp := database.NewChirp{
+			AuthorID:  authorID,
+			Body:      body,
+			InReplyTo: chirp.InReplyTo,
+			QuotedChirpID: chirp.QuotedChirpID,
+			MediaIDs: chirp.MediaIDs,
+		}
+		if chirp.PublishAt != nil && chirp.PublishAt.After(time.Now()) {
+			saveDraft(w, db, newChirp, chirp.PublishAt)
 			return
 		}
 
-		claims, ok := token.Claims.(*jwt.RegisteredClaims)
-		
-		// Check if the token is a refresh token
-		if claims.Issuer != "chirpy-access" {
-			http.Error(w, "Invalid token: Must be a Access Token, please refresh after it is invalid", http.StatusUnauthorized)
+		createdChirp, err := db.CreateChirp(newChirp)
+		if errors.Is(err, database.ErrBlocked) {
+			respondWithError(w, http.StatusForbidden, err.Error())
 			return
 		}
-		
-		// Check if the token has been revoked	
-		if !ok || claims.ExpiresAt == nil || claims.ExpiresAt.Before(time.Now().UTC()) {
-			http.Error(w, "Refresh Token has been revoked!", http.StatusUnauthorized)
+		if isReferenceError(err) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-
-		authorID, err := strconv.Atoi(claims.Subject)
-		if err != nil {
-			return 
-		}
-
-		createdChirp, err := db.CreateChirp(authorID, chirp.Body)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -84,7 +77,7 @@ func CreateChirpsHandler(db *database.DB, apiCfg *config.ApiConfig) http.Handler
 	}
 }
 
-func GetChirpIDHandler(db *database.DB) http.HandlerFunc {
+func GetChirpIDHandler(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		idStr := chi.URLParam(r, "id")
 		id, err := strconv.Atoi(idStr)
@@ -92,51 +85,50 @@ func GetChirpIDHandler(db *database.DB) http.HandlerFunc {
 			http.Error(w, "Invalid id", http.StatusBadRequest)
 			return
 		}
-		chirps, err := db.GetChirps()
+		chirp, err := db.GetChirp(id)
+		// Deleted chirps are only kept as tombstones for threads and quotes
+		if errors.Is(err, database.ErrChirpNotFound) || err == nil && chirp.Deleted {
+			respondWithError(w, http.StatusNotFound, database.ErrChirpNotFound.Error())
+			return
+		}
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		respondWithJSON(w, http.StatusOK, chirps[id-1])
+		respondWithJSON(w, http.StatusOK, chirp)
 	}
 }
 
-func GetChirpsHandler(db *database.DB) http.HandlerFunc {
+func GetChirpsHandler(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		chirps, err := db.GetChirps()
+		page, err := pageFromRequest(r)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		a := r.URL.Query().Get("author_id")
-		s := r.URL.Query().Get("sort")
-
 		var result []database.Chirp
-		for _, chirp := range chirps {
-			if a == strconv.Itoa(chirp.AuthorID) {
-				result = append(result, chirp)
-			} 
+		if a := r.URL.Query().Get("author_id"); a != "" {
+			authorID, err := strconv.Atoi(a)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid author_id")
+				return
+			}
+			result, err = db.GetChirpsByAuthor(authorID, lookahead(page))
+		} else {
+			result, err = db.GetChirps(lookahead(page))
 		}
-		if len(result) == 0 {
-			result = chirps
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
 		}
 
-		if s == "asc" {
-			sort.Slice(result, func(i, j int) bool {
-				return result[i].ID < result[j].ID
-			})
-		}; if s == "desc" {
-			sort.Slice(result, func(i, j int) bool {
-				return result[i].ID > result[j].ID
-			})
-		}
-		respondWithJSON(w, http.StatusOK, result)
+		respondWithPage(w, r, page, result, func(c database.Chirp) int { return c.ID })
 	}
 }
 
-func DeleteChirpIDHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerFunc {
+func DeleteChirpIDHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		idStr := chi.URLParam(r, "id")
 		id, err := strconv.Atoi(idStr)
@@ -145,48 +137,23 @@ func DeleteChirpIDHandler(db *database.DB, apiCfg *config.ApiConfig) http.Handle
 			return
 		}
 
-		//Get the authorID from JWT header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
-			return
-		}
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(apiCfg.JwtSecret), nil
-		})
+		authorID, err := authenticate(r, apiCfg)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-		if !token.Valid {
-			http.Error(w, "Invalid token, please refresh after it is invalid", http.StatusUnauthorized)
+			respondWithError(w, http.StatusUnauthorized, err.Error())
 			return
 		}
 
-		claims, ok := token.Claims.(*jwt.RegisteredClaims)
-		
-		if claims.Issuer != "chirpy-access" {
-			http.Error(w, "Invalid token: Must be a Access Token, please refresh after it is invalid", http.StatusUnauthorized)
+		err = db.DeleteChirp(authorID, id)
+		switch {
+		case errors.Is(err, database.ErrChirpNotFound):
+			respondWithError(w, http.StatusNotFound, err.Error())
 			return
-		}
-		
-		if !ok || claims.ExpiresAt == nil || claims.ExpiresAt.Before(time.Now().UTC()) {
-			http.Error(w, "Refresh Token has been revoked!", http.StatusUnauthorized)
+		case errors.Is(err, database.ErrNotChirpAuthor):
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
 			return
-		}
-
-		authorID, err := strconv.Atoi(claims.Subject)
-		if err != nil {
-			return 
-		}
-
-		err = db.DeleteChirp(authorID, id)
-		if err != nil {
-			return 
 		}
 
 		w.WriteHeader(http.StatusOK)
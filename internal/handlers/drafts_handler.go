@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+type CreateDraftRequest struct {
+	Body          string `json:"body"`
+	InReplyTo     int    `json:"in_reply_to"`
+	QuotedChirpID int    `json:"quoted_chirp_id"`
+	MediaIDs      []int  `json:"media_ids"`
+	// PublishAt schedules the draft to be published at that time, if set
+	PublishAt *time.Time `json:"publish_at"`
+}
+
+// CreateDraftHandler saves a draft for the caller, scheduled if the request
+// has a publish_at time, which must be in the future
+func CreateDraftHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateDraftRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		if req.PublishAt != nil && !req.PublishAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "publish_at must be in the future")
+			return
+		}
+		body, ok := cleanChirp(w, db, apiCfg, userID, req.Body)
+		if !ok {
+			return
+		}
+		saveDraft(w, db, database.NewChirp{
+			AuthorID:      userID,
+			Body:          body,
+			InReplyTo:     req.InReplyTo,
+			QuotedChirpID: req.QuotedChirpID,
+			MediaIDs:      req.MediaIDs,
+		}, req.PublishAt)
+	}
+}
+
+// saveDraft creates the draft and writes it as the response
+func saveDraft(w http.ResponseWriter, db database.Store, c database.NewChirp, publishAt *time.Time) {
+	if publishAt != nil {
+		t := publishAt.UTC()
+		publishAt = &t
+	}
+
+	draft, err := db.CreateDraft(c, publishAt)
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if isReferenceError(err) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	status := http.StatusCreated
+	if draft.PublishAt != nil {
+		status = http.StatusAccepted
+	}
+	respondWithJSON(w, status, draft)
+}
+
+// isReferenceError reports whether err is about a chirp or media a new chirp
+// refers to
+func isReferenceError(err error) bool {
+	return errors.Is(err, database.ErrParentNotFound) || errors.Is(err, database.ErrQuotedNotFound) ||
+		errors.Is(err, database.ErrMediaNotFound) || errors.Is(err, database.ErrTooManyMedia) ||
+		errors.Is(err, database.ErrDuplicateMedia)
+}
+
+// GetDraftsHandler lists the caller's drafts that are scheduled, or those
+// that are not
+func GetDraftsHandler(db database.Store, apiCfg *config.ApiConfig, scheduled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		page, err := pageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		drafts, err := db.GetDrafts(userID, scheduled, lookahead(page))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithPage(w, r, page, drafts, func(d database.Draft) int { return d.ID })
+	}
+}
+
+// DeleteDraftHandler discards the caller's draft with the {id} URL
+// parameter, which cancels it if it is scheduled
+func DeleteDraftHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		err = db.DeleteDraft(userID, id)
+		if errors.Is(err, database.ErrDraftNotFound) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// PublishDraftHandler publishes the caller's draft with the {id} URL
+// parameter as a chirp right away
+func PublishDraftHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		chirp, err := db.PublishDraft(userID, id)
+		switch {
+		case errors.Is(err, database.ErrDraftNotFound):
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		case errors.Is(err, database.ErrParentNotFound), errors.Is(err, database.ErrQuotedNotFound):
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		case errors.Is(err, database.ErrBlocked):
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, chirp)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+type EditChirpRequest struct {
+	Body string `json:"body"`
+}
+
+// EditChirpHandler replaces the body of the chirp with the {id} URL
+// parameter. Only its author can edit it, within apiCfg.EditWindow of posting
+// and, if apiCfg.EditRequiresRed is set, only as a Chirpy Red member. The new
+// body goes through the same validation as a new chirp.
+func EditChirpHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		var req EditChirpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		user, err := db.GetUser(userID)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if apiCfg.EditRequiresRed && !user.Membership {
+			respondWithError(w, http.StatusForbidden, "Editing chirps requires Chirpy Red")
+			return
+		}
+
+		cleaned, err := apiCfg.Validation.Run(req.Body, user.Membership)
+		if err != nil {
+			respondWithValidationError(w, err)
+			return
+		}
+
+		chirp, err := db.EditChirp(userID, id, cleaned.Body, apiCfg.EditWindow)
+		switch {
+		case errors.Is(err, database.ErrChirpNotFound):
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		case errors.Is(err, database.ErrNotChirpAuthor), errors.Is(err, database.ErrEditWindowClosed), errors.Is(err, database.ErrBlocked):
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, chirp)
+	}
+}
+
+// GetRevisionsHandler lists the earlier bodies of the chirp with the {id} URL
+// parameter, oldest first
+func GetRevisionsHandler(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		page, err := pageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		revisions, err := db.GetRevisions(id, lookahead(page))
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithPage(w, r, page, revisions, func(rev database.Revision) int { return rev.ID })
+	}
+}
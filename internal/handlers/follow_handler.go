@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+// FollowHandler makes the caller follow the user with the {id} URL
+// parameter, or unfollow them on DELETE. Both are idempotent and respond
+// with the user's follow counts.
+func FollowHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		followeeID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			err = db.Unfollow(userID, followeeID)
+		} else {
+			err = db.Follow(userID, followeeID)
+		}
+		switch {
+		case errors.Is(err, database.ErrUserNotFound):
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		case errors.Is(err, database.ErrFollowSelf):
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		case errors.Is(err, database.ErrBlocked):
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		counts, err := db.CountFollows(followeeID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, counts)
+	}
+}
+
+// GetFollowsHandler lists the followers of the user with the {id} URL
+// parameter, or the users they follow, in the order they followed. The
+// X-Total-Count header has the length of the whole list.
+func GetFollowsHandler(db database.Store, followers bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		page, err := pageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		list, total := db.GetFollowing, func(c database.FollowCounts) int { return c.Following }
+		if followers {
+			list, total = db.GetFollowers, func(c database.FollowCounts) int { return c.Followers }
+		}
+		counts, err := db.CountFollows(userID)
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		users, err := list(userID, lookahead(page))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.Header().Set("X-Total-Count", strconv.Itoa(total(counts)))
+		respondWithPage(w, r, page, users, func(u database.FollowUser) int { return u.FollowID })
+	}
+}
+
+// TimelineHandler lists the chirps of the caller and the users they follow,
+// newest first unless sort=asc is given
+func TimelineHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		// Default to newest first, keeping it in the next page's link
+		if query := r.URL.Query(); query.Get("sort") == "" {
+			query.Set("sort", "desc")
+			r.URL.RawQuery = query.Encode()
+		}
+		page, err := pageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		chirps, err := db.GetTimeline(userID, lookahead(page))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithPage(w, r, page, chirps, func(c database.Chirp) int { return c.ID })
+	}
+}
@@ -25,9 +25,10 @@ type LoginResponse struct {
 	AccessToken string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	Membership bool `json:"is_chirpy_red"`
+	EmailVerified bool `json:"email_verified"`
 }
 
-func LoginHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerFunc {
+func LoginHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Parse the request body
 		var req LoginRequest
@@ -97,6 +98,7 @@ func LoginHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerFunc {
 			AccessToken:  accessTokenString,
 			RefreshToken: refreshTokenString,
 			Membership:   user.Membership,
+			EmailVerified: user.EmailVerified,
 		}
 		json.NewEncoder(w).Encode(res)
 	}
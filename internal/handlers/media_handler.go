@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+	"github.com/lordmoma/chirpy/internal/media"
+)
+
+// UploadMediaHandler stores the image in the "file" field of a multipart
+// upload, with its thumbnail, for the caller to attach to chirps
+func UploadMediaHandler(db database.Store, blobs media.BlobStore, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		// Leave room for the multipart headers around the file
+		r.Body = http.MaxBytesReader(w, r.Body, media.MaxImageSize+1<<20)
+		data, err := readUpload(r, "file")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) || errors.Is(err, media.ErrImageTooLarge) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, media.ErrImageTooLarge.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		img, err := media.Process(data)
+		switch {
+		case errors.Is(err, media.ErrUnsupportedType):
+			respondWithError(w, http.StatusUnsupportedMediaType, err.Error())
+			return
+		case errors.Is(err, media.ErrImageTooLarge):
+			respondWithError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		key, err := randomID()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		m := database.Media{
+			OwnerID:       userID,
+			Key:           key,
+			ThumbnailKey:  key + "-thumb",
+			ContentType:   img.ContentType,
+			ThumbnailType: img.ThumbnailType,
+			Size:          int64(len(data)),
+			Width:         img.Width,
+			Height:        img.Height,
+		}
+		if err := blobs.Put(m.Key, bytes.NewReader(data)); err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := blobs.Put(m.ThumbnailKey, bytes.NewReader(img.Thumbnail)); err != nil {
+			blobs.Delete(m.Key)
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		created, err := db.CreateMedia(m)
+		if err != nil {
+			blobs.Delete(m.Key)
+			blobs.Delete(m.ThumbnailKey)
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, created)
+	}
+}
+
+// readUpload returns the contents of the named file field of a multipart
+// request, or media.ErrImageTooLarge if it is over media.MaxImageSize
+func readUpload(r *http.Request, field string) ([]byte, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("missing " + field + " field")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != field {
+			part.Close()
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, media.MaxImageSize+1))
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > media.MaxImageSize {
+			return nil, media.ErrImageTooLarge
+		}
+		return data, nil
+	}
+}
+
+// randomID returns 128 random bits in hex, for blob keys and token ids
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GetMediaHandler serves the image with the {id} URL parameter, or its
+// thumbnail with ?size=thumbnail. Stored images never change, so clients
+// and proxies may cache them for good.
+func GetMediaHandler(db database.Store, blobs media.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		m, err := db.GetMedia(id)
+		if errors.Is(err, database.ErrMediaNotFound) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		key, contentType := m.Key, m.ContentType
+		switch r.URL.Query().Get("size") {
+		case "", "original":
+		case "thumbnail":
+			key, contentType = m.ThumbnailKey, m.ThumbnailType
+		default:
+			respondWithError(w, http.StatusBadRequest, "Invalid size, must be original or thumbnail")
+			return
+		}
+
+		etag := `"` + key + `"`
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", m.CreatedAt.UTC().Format(http.TimeFormat))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		blob, err := blobs.Open(key)
+		if errors.Is(err, media.ErrBlobNotFound) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer blob.Close()
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if key == m.Key {
+			w.Header().Set("Content-Length", strconv.FormatInt(m.Size, 10))
+		}
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, blob)
+	}
+}
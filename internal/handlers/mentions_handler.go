@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+// GetMentionsHandler lists the chirps that mention the user with the {id} URL parameter
+func GetMentionsHandler(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		page, err := pageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if _, err := db.GetUser(userID); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		chirps, err := db.GetMentions(userID, lookahead(page))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithPage(w, r, page, chirps, func(c database.Chirp) int { return c.ID })
+	}
+}
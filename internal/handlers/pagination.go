@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+// Every list endpoint pages the same way: `limit` caps the page size, `sort`
+// picks ascending (the default) or descending ids, and `cursor` continues
+// from the previous page. When there are more results the response carries
+// the cursor for the next page in an X-Next-Cursor header and a Link header
+// with rel="next". The body is the plain JSON array of results.
+//
+// Lists ordered by id continue after the last id of the previous page.
+// Ranked lists, such as search results, have no stable order to continue
+// from, so their cursors hold an offset instead.
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// cursor is the position encoded in the opaque cursor parameter
+type cursor struct {
+	After  int  `json:"after,omitempty"`
+	Desc   bool `json:"desc,omitempty"`
+	Offset int  `json:"offset,omitempty"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errors.New("Invalid cursor")
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.After < 0 || c.Offset < 0 {
+		return c, errors.New("Invalid cursor")
+	}
+	return c, nil
+}
+
+// limitFromRequest reads the limit query parameter
+func limitFromRequest(r *http.Request) (int, error) {
+	l := r.URL.Query().Get("limit")
+	if l == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(l)
+	if err != nil || limit < 1 {
+		return 0, errors.New("Invalid limit")
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return limit, nil
+}
+
+// pageFromRequest reads the limit, sort and cursor query parameters of a list ordered by id
+func pageFromRequest(r *http.Request) (database.Page, error) {
+	query := r.URL.Query()
+	page := database.Page{Desc: query.Get("sort") == "desc"}
+
+	limit, err := limitFromRequest(r)
+	if err != nil {
+		return page, err
+	}
+	page.Limit = limit
+
+	if s := query.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil || c.After == 0 {
+			return page, errors.New("Invalid cursor")
+		}
+		if c.Desc != page.Desc {
+			return page, errors.New("Cursor does not match the sort order")
+		}
+		page.After = c.After
+	}
+	return page, nil
+}
+
+// lookahead asks for one more record than the page holds, so respondWithPage
+// can tell whether there is a next page
+func lookahead(page database.Page) database.Page {
+	page.Limit++
+	return page
+}
+
+// rankedPageFromRequest reads the limit and cursor query parameters of a ranked list
+func rankedPageFromRequest(r *http.Request) (offset, limit int, err error) {
+	limit, err = limitFromRequest(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil || c.After != 0 {
+			return 0, 0, errors.New("Invalid cursor")
+		}
+		offset = c.Offset
+	}
+	return offset, limit, nil
+}
+
+// respondWithPage writes items, fetched with lookahead(page), as one page of results
+func respondWithPage[T any](w http.ResponseWriter, r *http.Request, page database.Page, items []T, id func(T) int) {
+	if len(items) > page.Limit {
+		items = items[:page.Limit]
+		setNextCursor(w, r, cursor{After: id(items[len(items)-1]), Desc: page.Desc})
+	}
+	if items == nil {
+		items = []T{}
+	}
+	respondWithJSON(w, http.StatusOK, items)
+}
+
+// respondWithRankedPage writes items, fetched with limit+1 from offset, as one page of results
+func respondWithRankedPage[T any](w http.ResponseWriter, r *http.Request, offset, limit int, items []T) {
+	if len(items) > limit {
+		items = items[:limit]
+		setNextCursor(w, r, cursor{Offset: offset + limit})
+	}
+	if items == nil {
+		items = []T{}
+	}
+	respondWithJSON(w, http.StatusOK, items)
+}
+
+// setNextCursor points the response at the page starting from next
+func setNextCursor(w http.ResponseWriter, r *http.Request, next cursor) {
+	encoded := encodeCursor(next)
+	query := r.URL.Query()
+	query.Set("cursor", encoded)
+	w.Header().Set("X-Next-Cursor", encoded)
+	w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode()))
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+	"github.com/lordmoma/chirpy/internal/mail"
+)
+
+// passwordResetTTL is how long a password reset token can be used
+const passwordResetTTL = time.Hour
+
+// RequestPasswordResetHandler emails a password reset token to the user
+// with the email in the request body. It responds 202 Accepted whether or
+// not anyone has that email, so it can't be used to find out who has an
+// account.
+func RequestPasswordResetHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if email, err := database.NormalizeEmail(req.Email); err == nil {
+			if err := sendPasswordReset(db, apiCfg, email); err != nil {
+				log.Printf("starting password reset: %v", err)
+			}
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// sendPasswordReset emails a new password reset token to the user with
+// email, if there is one and they weren't sent one too recently. The email
+// is sent in the background so the response takes as long either way.
+func sendPasswordReset(db database.Store, apiCfg *config.ApiConfig, email string) error {
+	token, err := randomID()
+	if err != nil {
+		return err
+	}
+	user, err := db.StartPasswordReset(email, token, time.Now().UTC(), apiCfg.PasswordResetInterval)
+	if errors.Is(err, database.ErrUserNotFound) || errors.Is(err, database.ErrResetTooSoon) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi @%s,\n\nUse this token to choose a new password within the next %s:\n\n%s\n\nIf you didn't ask to reset your password, you can ignore this email.\n",
+		user.Handle, passwordResetTTL, token)
+	if apiCfg.ResetPasswordURL != "" {
+		body = fmt.Sprintf("Hi @%s,\n\nFollow this link to choose a new password within the next %s:\n\n%s?token=%s\n\nIf you didn't ask to reset your password, you can ignore this email.\n",
+			user.Handle, passwordResetTTL, apiCfg.ResetPasswordURL, url.QueryEscape(token))
+	}
+	go func() {
+		if err := apiCfg.Mailer.Send(mail.Message{To: user.Email, Subject: "Reset your password", Body: body}); err != nil {
+			log.Printf("sending password reset email to user %d: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// ConfirmPasswordResetHandler sets a new password for the user a password
+// reset token was sent to. Each token works once, and only the latest sent
+// does. All the user's refresh tokens stop working, so they have to log in
+// again everywhere.
+func ConfirmPasswordResetHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Password == "" {
+			respondWithError(w, http.StatusBadRequest, "password is required")
+			return
+		}
+
+		user, err := db.ResetPassword(req.Token, req.Password, time.Now().UTC(), passwordResetTTL)
+		switch {
+		case errors.Is(err, database.ErrInvalidPasswordReset):
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, UserResponse{ID: user.ID, Email: user.Email, Handle: user.Handle, EmailVerified: user.EmailVerified})
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+// updateProfileRequest is the body of PATCH /api/me/profile. Fields left
+// out are not changed.
+type updateProfileRequest struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	// AvatarID is the id of an image uploaded to /api/media, 0 to remove it
+	AvatarID *int `json:"avatar_id"`
+}
+
+// GetProfileHandler responds with the public profile of the user with the
+// {id} URL parameter
+func GetProfileHandler(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		respondWithProfile(w, db, userID)
+	}
+}
+
+// GetProfileByHandleHandler responds with the public profile of the user
+// with the {handle} URL parameter, with or without its leading @
+func GetProfileByHandleHandler(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handle, err := database.NormalizeHandle(chi.URLParam(r, "handle"))
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, database.ErrUserNotFound.Error())
+			return
+		}
+		user, err := db.GetUserByHandle(handle)
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondWithProfile(w, db, user.ID)
+	}
+}
+
+// UpdateProfileHandler changes the caller's handle, display name, bio or
+// avatar and responds with their new profile
+func UpdateProfileHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		var req updateProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		_, err = db.UpdateProfile(userID, database.ProfileUpdate{
+			Handle:      req.Handle,
+			DisplayName: req.DisplayName,
+			Bio:         req.Bio,
+			AvatarID:    req.AvatarID,
+		})
+		switch {
+		case errors.Is(err, database.ErrUserNotFound):
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		case errors.Is(err, database.ErrInvalidHandle), errors.Is(err, database.ErrDisplayNameTooLong),
+			errors.Is(err, database.ErrBioTooLong), errors.Is(err, database.ErrMediaNotFound):
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		case errors.Is(err, database.ErrHandleTaken):
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithProfile(w, db, userID)
+	}
+}
+
+// respondWithProfile writes the public profile of the user
+func respondWithProfile(w http.ResponseWriter, db database.Store, userID int) {
+	profile, err := db.GetProfile(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, profile)
+}
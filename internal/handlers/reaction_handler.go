@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+// ReactHandler adds the caller's reaction of kind (database.ReactionLike or
+// database.ReactionRechirp) to the chirp with the {id} URL parameter, or
+// removes it on DELETE. Both are idempotent and respond with the chirp and
+// its updated counts.
+func ReactHandler(db database.Store, apiCfg *config.ApiConfig, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chirpID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		var chirp database.Chirp
+		if r.Method == http.MethodDelete {
+			chirp, err = db.Unreact(kind, userID, chirpID)
+		} else {
+			chirp, err = db.React(kind, userID, chirpID)
+		}
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, database.ErrBlocked) {
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, chirp)
+	}
+}
+
+// GetLikesHandler lists the chirps liked by the user with the {id} URL
+// parameter, in the order they were liked
+func GetLikesHandler(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		page, err := pageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if _, err := db.GetUser(userID); err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		chirps, err := db.GetLikes(userID, lookahead(page))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithPage(w, r, page, chirps, func(c database.LikedChirp) int { return c.LikeID })
+	}
+}
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,7 +18,7 @@ type RefreshResponse struct {
 	Token string `json:"token"`
 }
 
-func AccessTokenHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerFunc {
+func AccessTokenHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Extract the token from the headers
 		authHeader := r.Header.Get("Authorization")
@@ -61,6 +63,26 @@ func AccessTokenHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerF
 			return
 		}
 
+		// Resetting the password revokes every refresh token issued before it
+		userID, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		user, err := db.GetUser(userID)
+		if errors.Is(err, database.ErrUserNotFound) {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if claims.IssuedAt == nil || user.TokenRevoked(claims.IssuedAt.Time) {
+			http.Error(w, "Refresh Token has been revoked!", http.StatusUnauthorized)
+			return
+		}
+
 		newAccessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 1)),
 		})
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+// RestrictHandler makes the caller block or mute, as kind says, the user
+// with the {id} URL parameter, or lift the block or mute on DELETE. Both are
+// idempotent. Blocking also ends any follows between the two users.
+func RestrictHandler(db database.Store, apiCfg *config.ApiConfig, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		targetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			err = db.Unrestrict(kind, userID, targetID)
+		} else {
+			err = db.Restrict(kind, userID, targetID)
+		}
+		switch {
+		case errors.Is(err, database.ErrUserNotFound):
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		case errors.Is(err, database.ErrRestrictSelf):
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetRestrictedHandler lists the users the caller has blocked or muted, as
+// kind says, in the order they were
+func GetRestrictedHandler(db database.Store, apiCfg *config.ApiConfig, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		page, err := pageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		users, err := db.GetRestricted(kind, userID, lookahead(page))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithPage(w, r, page, users, func(u database.RestrictedUser) int { return u.RestrictionID })
+	}
+}
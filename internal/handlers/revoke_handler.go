@@ -17,7 +17,7 @@ type RevokeResponse struct {
 	RevokedAt time.Time `json:"revoked_at"`
 }
 
-func RevokeTokenHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerFunc {
+func RevokeTokenHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Extract the token from the headers
 		authHeader := r.Header.Get("Authorization")
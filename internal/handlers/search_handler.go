@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+// SearchChirpsHandler finds chirps by words and "quoted phrases" in q, most
+// relevant and recent first. author_id, since and until narrow the search;
+// since and until take an RFC 3339 time or a date, and until includes the
+// whole of a date.
+func SearchChirpsHandler(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		offset, limit, err := rankedPageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		q := database.SearchQuery{
+			Text:   query.Get("q"),
+			Offset: offset,
+			Limit:  limit + 1,
+		}
+
+		if a := query.Get("author_id"); a != "" {
+			if q.AuthorID, err = strconv.Atoi(a); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid author_id")
+				return
+			}
+		}
+		if q.Since, err = parseTimeParam(query.Get("since"), false); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid since")
+			return
+		}
+		if q.Until, err = parseTimeParam(query.Get("until"), true); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid until")
+			return
+		}
+
+		chirps, err := db.SearchChirps(q)
+		if errors.Is(err, database.ErrEmptySearch) {
+			respondWithError(w, http.StatusBadRequest, "Search query q has no words")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithRankedPage(w, r, offset, limit, chirps)
+	}
+}
+
+// parseTimeParam parses an RFC 3339 time or a YYYY-MM-DD date. With endOfDay
+// a date means the end of that day rather than its start. An empty value is
+// the zero time.
+func parseTimeParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
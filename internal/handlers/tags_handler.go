@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 30 * 24 * time.Hour
+)
+
+// GetTagChirpsHandler lists the chirps tagged with the {tag} URL parameter
+func GetTagChirpsHandler(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, err := pageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		tag := database.NormalizeTag(chi.URLParam(r, "tag"))
+		chirps, err := db.GetChirpsByTag(tag, lookahead(page))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithPage(w, r, page, chirps, func(c database.Chirp) int { return c.ID })
+	}
+}
+
+// TrendingTagsHandler lists the tags used most over the last `window`, a
+// duration such as "6h" (default 24h, at most 30 days)
+func TrendingTagsHandler(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offset, limit, err := rankedPageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		window := defaultTrendingWindow
+		if wp := r.URL.Query().Get("window"); wp != "" {
+			window, err = time.ParseDuration(wp)
+			if err != nil || window <= 0 || window > maxTrendingWindow {
+				respondWithError(w, http.StatusBadRequest, "Invalid window")
+				return
+			}
+		}
+
+		tags, err := db.TrendingTags(time.Now().UTC().Add(-window), offset, limit+1)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithRankedPage(w, r, offset, limit, tags)
+	}
+}
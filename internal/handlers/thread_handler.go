@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+const (
+	defaultThreadDepth = 3
+	maxThreadDepth     = 10
+)
+
+// GetThreadHandler returns the chirp with the {id} URL parameter together
+// with the chirps it replies to and a page of its replies. `depth` sets how
+// many levels of replies are nested (default 3, at most 10); the page
+// parameters apply to the direct replies.
+func GetThreadHandler(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		page, err := pageFromRequest(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		depth := defaultThreadDepth
+		if d := r.URL.Query().Get("depth"); d != "" {
+			depth, err = strconv.Atoi(d)
+			if err != nil || depth < 1 {
+				respondWithError(w, http.StatusBadRequest, "Invalid depth")
+				return
+			}
+			if depth > maxThreadDepth {
+				depth = maxThreadDepth
+			}
+		}
+
+		thread, err := db.GetThread(id, lookahead(page), depth)
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if len(thread.Replies) > page.Limit {
+			thread.Replies = thread.Replies[:page.Limit]
+			setNextCursor(w, r, cursor{After: thread.Replies[page.Limit-1].ID, Desc: page.Desc})
+		}
+		respondWithJSON(w, http.StatusOK, thread)
+	}
+}
@@ -2,13 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
-	"strings"
-	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/lordmoma/chirpy/internal/config"
 	"github.com/lordmoma/chirpy/internal/database"
 )
@@ -16,17 +13,23 @@ import (
 type CreateUserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Handle is optional; one is derived from the email if it is empty
+	Handle string `json:"handle"`
 }
 type UserResponse struct {
-	ID    int    `json:"id"`
-	Email string `json:"email"`
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	Handle        string `json:"handle"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 // type updateResponse struct {
 // 	Email string `json:"email"`
 // }
 
-func CreateUserHandler(db *database.DB) http.HandlerFunc {
+// CreateUserHandler creates a user and emails them a token to verify their
+// email address
+func CreateUserHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Parse the request body
 		var req CreateUserRequest
@@ -37,7 +40,11 @@ func CreateUserHandler(db *database.DB) http.HandlerFunc {
 		}
 
 		// Create the user
-		createdUser, err := db.CreateUser(req.Email, req.Password)
+		createdUser, err := db.CreateUser(req.Email, req.Password, req.Handle)
+		if errors.Is(err, database.ErrInvalidHandle) || errors.Is(err, database.ErrInvalidEmail) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -48,63 +55,22 @@ func CreateUserHandler(db *database.DB) http.HandlerFunc {
 		w.WriteHeader(http.StatusCreated)
 
 		res := UserResponse{
-			ID:    createdUser.ID,
-			Email: createdUser.Email,
+			ID:            createdUser.ID,
+			Email:         createdUser.Email,
+			Handle:        createdUser.Handle,
+			EmailVerified: createdUser.EmailVerified,
 		}
+		sendFirstVerification(db, apiCfg, createdUser.ID)
 
 		json.NewEncoder(w).Encode(res)
 	}
 }
 
-func UpdateUserHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerFunc {
+func UpdateUserHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Extract the token from the headers
-		// fmt.Printf("http request: %v", r)
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header missing", http.StatusUnauthorized)
-			return
-		}
-
-		//tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-		// Parse and validate the token
-		token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method jwt.SigningMethodHMAC
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			// Return the secret key used to sign the token
-			return []byte(apiCfg.JwtSecret), nil
-		})
+		userID, err := authenticate(r, apiCfg)
 		if err != nil {
-			fmt.Printf("token error: %v", err)
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-		if !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
-
-		// Check if the token has expired
-		claims, ok := token.Claims.(*jwt.RegisteredClaims)
-		if !ok || claims.ExpiresAt == nil || claims.ExpiresAt.Before(time.Now().UTC()) {
-			http.Error(w, "Token has expired", http.StatusUnauthorized)
-			return
-		}
-
-		// Check if the token is a refresh token
-		if claims.Issuer == "chirpy-refresh" {
-			http.Error(w, "Invalid token: Refresh Token Rejected", http.StatusUnauthorized)
-			return
-		}
-
-		// Extract the user ID from the token
-		userID, err := strconv.Atoi(claims.Subject)
-		if err != nil {
-			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			respondWithError(w, http.StatusUnauthorized, err.Error())
 			return
 		}
 
@@ -116,7 +82,11 @@ func UpdateUserHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerFu
 		}
 
 		// Update the user in the database
-		updatedUser, err := db.UpdateUser(userID, req.Email, req.Password)
+		updatedUser, err := db.UpdateUser(userID, req.Email, req.Password, req.Handle)
+		if errors.Is(err, database.ErrInvalidHandle) || errors.Is(err, database.ErrInvalidEmail) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if err != nil {
 			fmt.Printf("error updating user: %v", err)
 			respondWithError(w, http.StatusInternalServerError, err.Error())
@@ -128,8 +98,14 @@ func UpdateUserHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerFu
 		w.WriteHeader(http.StatusCreated)
 
 		res := UserResponse{
-			ID:    updatedUser.ID,
-			Email: updatedUser.Email,
+			ID:            updatedUser.ID,
+			Email:         updatedUser.Email,
+			Handle:        updatedUser.Handle,
+			EmailVerified: updatedUser.EmailVerified,
+		}
+		// A new email is unverified with no verification sent yet
+		if !updatedUser.EmailVerified && updatedUser.VerificationID == "" {
+			sendFirstVerification(db, apiCfg, updatedUser.ID)
 		}
 
 		json.NewEncoder(w).Encode(res)
@@ -2,12 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strings"
 
 	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+	"github.com/lordmoma/chirpy/internal/validation"
 )
 
+// ValidateHandler runs a chirp body through the validation pipeline without
+// posting it, as it would be for someone without Chirpy Red
 func ValidateHandler(cfg *config.ApiConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var chirp struct {
@@ -20,17 +24,50 @@ func ValidateHandler(cfg *config.ApiConfig) http.HandlerFunc {
 			return
 		}
 
-		cleanedBody := replaceProfane(chirp.Body)
+		cleaned, err := cfg.Validation.Run(chirp.Body, false)
+		if err != nil {
+			respondWithValidationError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, struct {
+			CleanedBody string   `json:"cleaned_body"`
+			URLs        []string `json:"urls,omitempty"`
+		}{cleaned.Body, cleaned.URLs})
+	}
+}
+
+// cleanChirp checks userID may post and runs the body of their chirp
+// through the validation pipeline, returning it as it should be stored. If
+// they can't post it, the response has been written and ok is false.
+func cleanChirp(w http.ResponseWriter, db database.Store, apiCfg *config.ApiConfig, userID int, body string) (cleaned string, ok bool) {
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return "", false
+	}
+	if apiCfg.RequireVerifiedEmail && !user.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "email address must be verified before posting")
+		return "", false
+	}
 
-		respondWithJSON(w, http.StatusOK, map[string]string{"cleaned_body": cleanedBody})
+	chirp, err := apiCfg.Validation.Run(body, user.Membership)
+	if err != nil {
+		respondWithValidationError(w, err)
+		return "", false
 	}
+	return chirp.Body, true
 }
 
-func replaceProfane(text string) string {
-	profane := []string{"kerfuffle", "sharbert", "fornax"}
-	for _, word := range profane {
-		text = strings.ReplaceAll(text, word, "****")
-		text = strings.ReplaceAll(text, strings.ToUpper(word), "****")
+// respondWithValidationError writes a 400 listing the rules a chirp failed
+func respondWithValidationError(w http.ResponseWriter, err error) {
+	var invalid *validation.Error
+	if !errors.As(err, &invalid) {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-	return text
+	respondWithJSON(w, http.StatusBadRequest, struct {
+		Error    string               `json:"error"`
+		Failures []validation.Failure `json:"failures"`
+	}{invalid.Error(), invalid.Failures})
 }
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lordmoma/chirpy/internal/config"
+	"github.com/lordmoma/chirpy/internal/database"
+	"github.com/lordmoma/chirpy/internal/mail"
+)
+
+const (
+	verificationIssuer = "chirpy-verify"
+	// verificationTTL is how long a verification token can be used
+	verificationTTL = 24 * time.Hour
+)
+
+// verificationClaims are the claims of a verification token. The token's id
+// is recorded on the user, so only their latest token works, and only once.
+type verificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// verificationKey returns the key verification tokens are signed with. It is
+// derived from the JWT secret but differs from it, so a token read from an
+// email can't pass for an access token.
+func verificationKey(apiCfg *config.ApiConfig) []byte {
+	mac := hmac.New(sha256.New, []byte(apiCfg.JwtSecret))
+	mac.Write([]byte(verificationIssuer))
+	return mac.Sum(nil)
+}
+
+// sendVerification emails the user a token verifying their email address,
+// unless the last one was sent less than minInterval ago
+func sendVerification(db database.Store, apiCfg *config.ApiConfig, userID int, minInterval time.Duration) error {
+	tokenID, err := randomID()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	user, err := db.StartEmailVerification(userID, tokenID, now, minInterval)
+	if err != nil {
+		return err
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, verificationClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    verificationIssuer,
+			Subject:   strconv.Itoa(user.ID),
+			ID:        tokenID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(verificationTTL)),
+		},
+	}).SignedString(verificationKey(apiCfg))
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi @%s,\n\nUse this token to verify your email address within the next %s:\n\n%s\n",
+		user.Handle, verificationTTL, token)
+	if apiCfg.VerifyEmailURL != "" {
+		body = fmt.Sprintf("Hi @%s,\n\nFollow this link to verify your email address within the next %s:\n\n%s?token=%s\n",
+			user.Handle, verificationTTL, apiCfg.VerifyEmailURL, url.QueryEscape(token))
+	}
+	return apiCfg.Mailer.Send(mail.Message{To: user.Email, Subject: "Verify your email address", Body: body})
+}
+
+// sendFirstVerification sends the first verification email for a new
+// account or email address in the background, so a slow mail server doesn't
+// hold up the response. The account is usable without it, so failures are
+// only logged; the user can ask for another.
+func sendFirstVerification(db database.Store, apiCfg *config.ApiConfig, userID int) {
+	go func() {
+		if err := sendVerification(db, apiCfg, userID, 0); err != nil {
+			log.Printf("sending verification email to user %d: %v", userID, err)
+		}
+	}()
+}
+
+// VerifyEmailHandler verifies the email address of the user a verification
+// token was sent to. Each token works once, and only the latest sent does.
+func VerifyEmailHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var claims verificationClaims
+		_, err := jwt.ParseWithClaims(req.Token, &claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return verificationKey(apiCfg), nil
+		}, jwt.WithIssuer(verificationIssuer))
+		if err != nil || claims.ExpiresAt == nil {
+			respondWithError(w, http.StatusBadRequest, database.ErrInvalidVerification.Error())
+			return
+		}
+		userID, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, database.ErrInvalidVerification.Error())
+			return
+		}
+
+		user, err := db.VerifyEmail(userID, claims.ID, claims.Email)
+		switch {
+		case errors.Is(err, database.ErrInvalidVerification), errors.Is(err, database.ErrUserNotFound):
+			respondWithError(w, http.StatusBadRequest, database.ErrInvalidVerification.Error())
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, UserResponse{ID: user.ID, Email: user.Email, Handle: user.Handle, EmailVerified: user.EmailVerified})
+	}
+}
+
+// ResendVerificationHandler sends the caller a new verification email,
+// which replaces any sent before. Callers must wait
+// apiCfg.VerificationResendInterval between emails.
+func ResendVerificationHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := authenticate(r, apiCfg)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		err = sendVerification(db, apiCfg, userID, apiCfg.VerificationResendInterval)
+		switch {
+		case errors.Is(err, database.ErrAlreadyVerified):
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		case errors.Is(err, database.ErrVerificationTooSoon):
+			if user, err := db.GetUser(userID); err == nil && user.VerificationSentAt != nil {
+				wait := time.Until(user.VerificationSentAt.Add(apiCfg.VerificationResendInterval))
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			}
+			respondWithError(w, http.StatusTooManyRequests, err.Error())
+			return
+		case errors.Is(err, database.ErrUserNotFound):
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		case err != nil:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
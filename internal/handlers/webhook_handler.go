@@ -22,7 +22,7 @@ type WebhookResponse struct {
 	Membership bool `json:"is_chirpy_red"`
 }
 
-func WebhookHandler(db *database.DB, apiCfg *config.ApiConfig) http.HandlerFunc{
+func WebhookHandler(db database.Store, apiCfg *config.ApiConfig) http.HandlerFunc{
 	return func(w http.ResponseWriter, r *http.Request){
 		fmt.Printf("http request: %v", r)
 		authHeader := r.Header.Get("Authorization")
@@ -0,0 +1,118 @@
+// Package mail sends the emails the server writes to users
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrInvalidHeader is returned for a message whose recipient or subject
+// could smuggle in extra headers
+var ErrInvalidHeader = errors.New("mail: invalid recipient or subject")
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// format returns msg as an RFC 5322 message from the address from
+func format(from string, msg Message, date time.Time) ([]byte, error) {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return nil, ErrInvalidHeader
+	}
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, ErrInvalidHeader
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
+
+// SMTPMailer sends messages through an SMTP server
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer returns a mailer sending through the server at addr
+// (host:port) as from. An empty username sends without authenticating.
+func NewSMTPMailer(addr, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send sends msg, upgrading the connection with STARTTLS if the server offers it
+func (m *SMTPMailer) Send(msg Message) error {
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, data)
+}
+
+// FileMailer writes each message to a file in a directory instead of sending
+// it, for local development and tests. With no directory it writes the
+// message to the log.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer returns a mailer saving messages from from to dir, creating
+// it if needed, or logging them if dir is empty
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send saves msg as a .eml file named after when it was sent and to whom
+func (m *FileMailer) Send(msg Message) error {
+	now := time.Now()
+	data, err := format(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+	if m.dir == "" {
+		log.Printf("mail:\n%s", data)
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), strings.NewReplacer("/", "_", "\\", "_").Replace(msg.To))
+	path := filepath.Join(m.dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	log.Printf("mail to %s saved to %s", msg.To, path)
+	return nil
+}
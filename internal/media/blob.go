@@ -0,0 +1,98 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBlobNotFound is returned when nothing is stored under the requested key
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps the bytes of uploaded files by key. FileStore keeps them
+// on the local filesystem; a store backed by S3 or a compatible service can
+// take its place.
+type BlobStore interface {
+	// Put stores the contents of r under key, replacing anything stored there
+	Put(key string, r io.Reader) error
+	// Open returns the contents stored under key, or ErrBlobNotFound
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes what is stored under key. Deleting a missing key is a no-op.
+	Delete(key string) error
+}
+
+// FileStore is a BlobStore keeping each blob in a file named after its key
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore keeping blobs in dir, creating it if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file for key. Keys are flat names, so they can't reach
+// outside the store's directory.
+func (s *FileStore) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Put writes the blob to a temporary file and renames it into place, so a
+// blob is never seen half written
+func (s *FileStore) Put(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (s *FileStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // registers GIF decoding
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// MaxImageSize is the largest image, in bytes, that can be uploaded
+	MaxImageSize = 5 << 20
+	// MaxImagePixels bounds the decoded size of an image, so a small file
+	// can't claim enormous dimensions
+	MaxImagePixels = 40_000_000
+	// ThumbnailSize is the longest side of a thumbnail, in pixels
+	ThumbnailSize = 320
+)
+
+var (
+	// ErrUnsupportedType is returned for files that aren't JPEG, PNG or GIF images
+	ErrUnsupportedType = errors.New("unsupported media type, must be a JPEG, PNG or GIF image")
+	// ErrImageTooLarge is returned for images over MaxImageSize or MaxImagePixels
+	ErrImageTooLarge = errors.New("image too large")
+)
+
+// Image is an uploaded image checked by Process, with its thumbnail
+type Image struct {
+	ContentType   string
+	Width, Height int
+	Thumbnail     []byte
+	ThumbnailType string
+}
+
+// Process checks that data is an image of a supported type and size and
+// makes its thumbnail. The type is sniffed from the data itself, whatever
+// the upload claims it is.
+func Process(data []byte) (Image, error) {
+	if len(data) > MaxImageSize {
+		return Image{}, ErrImageTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return Image{}, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrUnsupportedType
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxImagePixels {
+		return Image{}, ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrUnsupportedType
+	}
+
+	// Photos make JPEG thumbnails; PNG keeps the transparency of the others
+	var thumb bytes.Buffer
+	thumbType := "image/png"
+	if contentType == "image/jpeg" {
+		thumbType = "image/jpeg"
+		err = jpeg.Encode(&thumb, thumbnail(img, ThumbnailSize), &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(&thumb, thumbnail(img, ThumbnailSize))
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	return Image{
+		ContentType:   contentType,
+		Width:         cfg.Width,
+		Height:        cfg.Height,
+		Thumbnail:     thumb.Bytes(),
+		ThumbnailType: thumbType,
+	}, nil
+}
+
+// thumbnail scales img down so its longest side is at most size, averaging
+// the pixels each thumbnail pixel covers. Smaller images are kept as they are.
+func thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := b.Min.Y+ty*h/th, b.Min.Y+(ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := b.Min.X+tx*w/tw, b.Min.X+(tx+1)*w/tw
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := img.At(x, y).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			out.SetRGBA64(tx, ty, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return out
+}
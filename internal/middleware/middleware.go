@@ -20,8 +20,10 @@ func MiddlewareMetricsInc(next http.Handler, cfg *config.ApiConfig) http.Handler
 func MiddlewareCors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, PATCH, DELETE")
 		w.Header().Set("Access-Control-Allow-Headers", "*")
+		// Let browsers on other origins read the paging headers of lists
+		w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor, Link, X-Total-Count, Retry-After")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -0,0 +1,92 @@
+package validation
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	blankLines = regexp.MustCompile(`\n{3,}`)
+	urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+)
+
+// NormalizeWhitespace trims the chirp, collapses runs of spaces within a
+// line into one and allows at most one blank line in a row
+func NormalizeWhitespace(c *Chirp) *Failure {
+	lines := strings.Split(strings.ReplaceAll(c.Body, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.FieldsFunc(line, unicode.IsSpace), " ")
+	}
+	body := strings.Join(lines, "\n")
+	c.Body = strings.TrimSpace(blankLines.ReplaceAllString(body, "\n\n"))
+	return nil
+}
+
+// DetectURLs records the http and https links in the chirp
+func DetectURLs(c *Chirp) *Failure {
+	c.URLs = nil
+	for _, url := range urlPattern.FindAllString(c.Body, -1) {
+		// Punctuation ending a sentence isn't part of the link
+		url = strings.TrimRight(url, ".,;:!?'\")")
+		if strings.HasSuffix(url, "://") {
+			continue
+		}
+		c.URLs = append(c.URLs, url)
+	}
+	return nil
+}
+
+// length returns how many characters the chirp counts as, each link counting
+// as urlLength if it is above 0
+func length(c *Chirp, urlLength int) int {
+	n := utf8.RuneCountInString(c.Body)
+	if urlLength > 0 {
+		for _, url := range c.URLs {
+			n += urlLength - utf8.RuneCountInString(url)
+		}
+	}
+	return n
+}
+
+// MaskProfanity replaces each of words appearing in the chirp as a whole
+// word, in any case, with "****"
+func MaskProfanity(words []string) Step {
+	if len(words) == 0 {
+		return func(c *Chirp) *Failure { return nil }
+	}
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	pattern := regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+
+	return func(c *Chirp) *Failure {
+		c.Body = pattern.ReplaceAllString(c.Body, "****")
+		return nil
+	}
+}
+
+// LoadWordList reads a list of words from a file with one word per line.
+// Blank lines and lines starting with # are skipped.
+func LoadWordList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, strings.ToLower(word))
+	}
+	return words, scanner.Err()
+}
@@ -0,0 +1,134 @@
+// Package validation checks and cleans up the body of a new chirp before it
+// is stored. A Pipeline runs a series of steps over the body: some rewrite
+// it, such as masking profanity, and others reject it, such as the length
+// limit. Every rule that fails is reported, not just the first.
+package validation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the rules a chirp can fail
+const (
+	RuleRequired  = "required"
+	RuleMaxLength = "max_length"
+)
+
+// Failure is a rule a chirp failed
+type Failure struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error lists the rules a chirp failed
+type Error struct {
+	Failures []Failure
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		messages[i] = f.Message
+	}
+	return "invalid chirp: " + strings.Join(messages, "; ")
+}
+
+// Chirp is a chirp going through the pipeline
+type Chirp struct {
+	Body string
+	// Member is whether the author is a Chirpy Red member
+	Member bool
+	// URLs are the links found in the body
+	URLs []string
+}
+
+// Step rewrites the chirp, or returns a Failure if it breaks the step's rule
+type Step func(c *Chirp) *Failure
+
+// Config sets up the steps of a Pipeline
+type Config struct {
+	// MaxLength and MaxLengthRed are the most characters a chirp can have,
+	// for everyone and for Chirpy Red members
+	MaxLength    int
+	MaxLengthRed int
+	// URLLength is how many characters each link counts as, whatever its
+	// real length, 0 to count them as they are
+	URLLength int
+	// ProfaneWords are masked wherever they appear as whole words
+	ProfaneWords []string
+}
+
+// DefaultConfig returns the limits chirpy has always had, with Chirpy Red
+// members allowed twice the length
+func DefaultConfig() Config {
+	return Config{
+		MaxLength:    140,
+		MaxLengthRed: 280,
+		URLLength:    23,
+		ProfaneWords: []string{"kerfuffle", "sharbert", "fornax"},
+	}
+}
+
+// Pipeline runs its steps over each chirp in order
+type Pipeline struct {
+	steps []Step
+}
+
+// New returns the pipeline every new chirp goes through: whitespace is
+// normalised, empty chirps rejected, links found, the length checked and
+// profanity masked
+func New(cfg Config) *Pipeline {
+	return NewPipeline(
+		NormalizeWhitespace,
+		Required,
+		DetectURLs,
+		MaxLength(cfg.MaxLength, cfg.MaxLengthRed, cfg.URLLength),
+		MaskProfanity(cfg.ProfaneWords),
+	)
+}
+
+// NewPipeline returns a pipeline running steps in order
+func NewPipeline(steps ...Step) *Pipeline {
+	return &Pipeline{steps: steps}
+}
+
+// Run passes the chirp through every step and returns it as it should be
+// stored, or an *Error listing the rules it failed
+func (p *Pipeline) Run(body string, member bool) (Chirp, error) {
+	c := Chirp{Body: body, Member: member}
+	var failures []Failure
+	for _, step := range p.steps {
+		if f := step(&c); f != nil {
+			failures = append(failures, *f)
+		}
+	}
+	if len(failures) > 0 {
+		return Chirp{}, &Error{Failures: failures}
+	}
+	return c, nil
+}
+
+// Required rejects chirps with nothing in them
+func Required(c *Chirp) *Failure {
+	if strings.TrimSpace(c.Body) == "" {
+		return &Failure{Rule: RuleRequired, Message: "chirp is empty"}
+	}
+	return nil
+}
+
+// MaxLength rejects chirps longer than limit characters, or redLimit for
+// Chirpy Red members. Links count as urlLength characters each, if it is
+// above 0, so DetectURLs must run first.
+func MaxLength(limit, redLimit, urlLength int) Step {
+	return func(c *Chirp) *Failure {
+		max := limit
+		if c.Member && redLimit > max {
+			max = redLimit
+		}
+		if n := length(c, urlLength); n > max {
+			return &Failure{Rule: RuleMaxLength, Message: fmt.Sprintf("chirp is %d characters, the limit is %d", n, max)}
+		}
+		return nil
+	}
+}
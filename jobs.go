@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lordmoma/chirpy/internal/database"
+)
+
+const (
+	// trashPurgeInterval is how often chirps past database.TrashRetention are purged
+	trashPurgeInterval = time.Hour
+	// publishInterval is how often due scheduled chirps are published
+	publishInterval = 5 * time.Second
+)
+
+// startJobs runs the background jobs until the returned function is called,
+// which stops them and waits for them to finish
+func startJobs(db database.Store) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, job := range []func(context.Context, database.Store){purgeTrashLoop, publishScheduledLoop} {
+		wg.Add(1)
+		go func(job func(context.Context, database.Store)) {
+			defer wg.Done()
+			job(ctx, db)
+		}(job)
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// every calls f at startup and then every interval until ctx is done
+func every(ctx context.Context, interval time.Duration, f func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		f()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeTrashLoop permanently deletes chirps that have been in the trash for
+// longer than database.TrashRetention, at startup and then every
+// trashPurgeInterval until ctx is done
+func purgeTrashLoop(ctx context.Context, db database.Store) {
+	every(ctx, trashPurgeInterval, func() {
+		n, err := db.PurgeTrash(time.Now().UTC().Add(-database.TrashRetention))
+		if err != nil {
+			log.Printf("purging trash: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d chirps from the trash", n)
+		}
+	})
+}
+
+// publishScheduledLoop publishes scheduled drafts once they are due, every
+// publishInterval until ctx is done. The queue is the drafts in the
+// database, so chirps that fell due while the server was down are published
+// at startup.
+func publishScheduledLoop(ctx context.Context, db database.Store) {
+	every(ctx, publishInterval, func() {
+		due, err := db.DueDrafts(time.Now().UTC())
+		if err != nil {
+			log.Printf("listing scheduled chirps: %v", err)
+			return
+		}
+
+		for _, draft := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			_, err := db.PublishDraft(draft.AuthorID, draft.ID)
+			switch {
+			case errors.Is(err, database.ErrDraftNotFound):
+				// Cancelled since it was listed
+			case errors.Is(err, database.ErrParentNotFound), errors.Is(err, database.ErrQuotedNotFound),
+				errors.Is(err, database.ErrBlocked):
+				// Kept as a draft with the error, not retried
+				log.Printf("scheduled chirp %d not published: %v", draft.ID, err)
+			case err != nil:
+				log.Printf("publishing scheduled chirp %d: %v", draft.ID, err)
+				return
+			}
+		}
+	})
+}
@@ -8,14 +8,21 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/joho/godotenv"
 	"github.com/lordmoma/chirpy/internal/config"
 	"github.com/lordmoma/chirpy/internal/database"
 	"github.com/lordmoma/chirpy/internal/handlers"
+	"github.com/lordmoma/chirpy/internal/mail"
+	"github.com/lordmoma/chirpy/internal/media"
 	"github.com/lordmoma/chirpy/internal/middleware"
+	"github.com/lordmoma/chirpy/internal/validation"
 )
 
 func main() {
@@ -25,17 +32,30 @@ func main() {
 	}
 	jwtSecret := os.Getenv("JWT_SECRET")
 	apikey := os.Getenv("APIKey")
+	adminKey := os.Getenv("ADMIN_KEY")
 
 	apiCfg := &config.ApiConfig{
 		FileserverHits: 0,
 		JwtSecret:      jwtSecret,
 		APIKey: 	   apikey,
+		AdminKey:       adminKey,
 	}
+	apiCfg.EditWindow, apiCfg.EditRequiresRed = editConfig()
+	apiCfg.Validation = validation.New(validationConfig())
 	// fmt.Printf("JWT_SECRET: %s\n", apiCfg.JwtSecret)
 	// use flag package in Go to parse command line flags
 	debug := flag.Bool("debug", false, "enable debugging") // create a boolean value for the --debug flag
 
 	flag.Parse() // parse the command line flags
+
+	// Run a subcommand such as `chirpy migrate status` instead of the server
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if *debug {  // check the value of the debug flag
 		fmt.Println("Debugging enabled")
 	} else {
@@ -48,27 +68,88 @@ func main() {
 	// Create a new apiConfig struct to hold the request count
 	// apiCfg := &config.ApiConfig{}
 
+	mailConfig(apiCfg)
+
 	// Create a new Database
-	db, err := database.NewDB("database.json")
+	dbDriver, dbPath, dbOpts := databaseConfig()
+	db, err := database.Open(dbDriver, dbPath, dbOpts)
 	if err != nil {
 		panic(err)
 	}
 	if db == nil {
 		panic("Failed to open database file")
 	}
-	defer os.Remove("database.json")
+	defer db.Close()
+
+	if maxFollowers, size, timelines := timelineConfig(); maxFollowers > 0 {
+		db = database.NewTimelineCache(db, maxFollowers, size, timelines)
+	}
+
+	blobs, err := media.NewFileStore(mediaDir())
+	if err != nil {
+		log.Fatalf("opening media store: %v", err)
+	}
+
+	// Run the background jobs, stopping them before the database is closed
+	stopJobs := startJobs(db)
+	defer stopJobs()
 
 	// Create a new router for the /api namespace
 	apiRouter := chi.NewRouter()
 	apiRouter.Get("/healthz", handlers.HealthzHandler)
 	apiRouter.Post("/chirps", handlers.CreateChirpsHandler(db, apiCfg))
-	apiRouter.Get("/chirps", handlers.GetChirpsHandler(db))
-	apiRouter.Get("/chirps/{id}", handlers.GetChirpIDHandler(db))
+	apiRouter.Post("/validate_chirp", handlers.ValidateHandler(apiCfg))
+	apiRouter.Get("/chirps", handlers.AsViewer(db, apiCfg, handlers.GetChirpsHandler))
+	apiRouter.Get("/chirps/search", handlers.AsViewer(db, apiCfg, handlers.SearchChirpsHandler))
+	apiRouter.Get("/chirps/{id}", handlers.AsViewer(db, apiCfg, handlers.GetChirpIDHandler))
+	apiRouter.Get("/chirps/{id}/thread", handlers.AsViewer(db, apiCfg, handlers.GetThreadHandler))
+	apiRouter.Put("/chirps/{id}", handlers.EditChirpHandler(db, apiCfg))
+	apiRouter.Get("/chirps/{id}/revisions", handlers.AsViewer(db, apiCfg, handlers.GetRevisionsHandler))
 	apiRouter.Delete("/chirps/{id}", handlers.DeleteChirpIDHandler(db, apiCfg))
+	apiRouter.Post("/chirps/{id}/like", handlers.ReactHandler(db, apiCfg, database.ReactionLike))
+	apiRouter.Delete("/chirps/{id}/like", handlers.ReactHandler(db, apiCfg, database.ReactionLike))
+	apiRouter.Post("/chirps/{id}/rechirp", handlers.ReactHandler(db, apiCfg, database.ReactionRechirp))
+	apiRouter.Delete("/chirps/{id}/rechirp", handlers.ReactHandler(db, apiCfg, database.ReactionRechirp))
+
+	// hashtag timelines for /api namespaces
+	apiRouter.Get("/tags/trending", handlers.TrendingTagsHandler(db))
+	apiRouter.Get("/tags/{tag}/chirps", handlers.AsViewer(db, apiCfg, handlers.GetTagChirpsHandler))
 
 	// create users for /api namespaces
-	apiRouter.Post("/users", handlers.CreateUserHandler(db))
+	apiRouter.Post("/users", handlers.CreateUserHandler(db, apiCfg))
 	apiRouter.Put("/users", handlers.UpdateUserHandler(db, apiCfg))
+	apiRouter.Get("/users/{id}", handlers.GetProfileHandler(db))
+	apiRouter.Get("/users/by-handle/{handle}", handlers.GetProfileByHandleHandler(db))
+	apiRouter.Patch("/me/profile", handlers.UpdateProfileHandler(db, apiCfg))
+	apiRouter.Get("/users/{id}/mentions", handlers.AsViewer(db, apiCfg, handlers.GetMentionsHandler))
+	apiRouter.Get("/users/{id}/likes", handlers.AsViewer(db, apiCfg, handlers.GetLikesHandler))
+	apiRouter.Post("/users/{id}/follow", handlers.FollowHandler(db, apiCfg))
+	apiRouter.Delete("/users/{id}/follow", handlers.FollowHandler(db, apiCfg))
+	apiRouter.Get("/users/{id}/followers", handlers.GetFollowsHandler(db, true))
+	apiRouter.Get("/users/{id}/following", handlers.GetFollowsHandler(db, false))
+	apiRouter.Post("/users/{id}/block", handlers.RestrictHandler(db, apiCfg, database.RestrictionBlock))
+	apiRouter.Delete("/users/{id}/block", handlers.RestrictHandler(db, apiCfg, database.RestrictionBlock))
+	apiRouter.Post("/users/{id}/mute", handlers.RestrictHandler(db, apiCfg, database.RestrictionMute))
+	apiRouter.Delete("/users/{id}/mute", handlers.RestrictHandler(db, apiCfg, database.RestrictionMute))
+	apiRouter.Get("/me/blocks", handlers.GetRestrictedHandler(db, apiCfg, database.RestrictionBlock))
+	apiRouter.Get("/me/mutes", handlers.GetRestrictedHandler(db, apiCfg, database.RestrictionMute))
+	apiRouter.Get("/me/timeline", handlers.AsViewer(db, apiCfg, func(db database.Store) http.HandlerFunc {
+		return handlers.TimelineHandler(db, apiCfg)
+	}))
+	apiRouter.Get("/me/trash", handlers.GetTrashHandler(db, apiCfg))
+	apiRouter.Post("/me/trash/{id}/restore", handlers.RestoreChirpHandler(db, apiCfg))
+	apiRouter.Post("/media", handlers.UploadMediaHandler(db, blobs, apiCfg))
+	apiRouter.Get("/media/{id}", handlers.GetMediaHandler(db, blobs))
+	apiRouter.Post("/drafts", handlers.CreateDraftHandler(db, apiCfg))
+	apiRouter.Delete("/drafts/{id}", handlers.DeleteDraftHandler(db, apiCfg))
+	apiRouter.Post("/drafts/{id}/publish", handlers.PublishDraftHandler(db, apiCfg))
+	apiRouter.Get("/me/drafts", handlers.GetDraftsHandler(db, apiCfg, false))
+	apiRouter.Get("/me/scheduled", handlers.GetDraftsHandler(db, apiCfg, true))
+	apiRouter.Delete("/me/scheduled/{id}", handlers.DeleteDraftHandler(db, apiCfg))
+	apiRouter.Post("/verify-email", handlers.VerifyEmailHandler(db, apiCfg))
+	apiRouter.Post("/verify-email/resend", handlers.ResendVerificationHandler(db, apiCfg))
+	apiRouter.Post("/password-reset/request", handlers.RequestPasswordResetHandler(db, apiCfg))
+	apiRouter.Post("/password-reset/confirm", handlers.ConfirmPasswordResetHandler(db, apiCfg))
 	apiRouter.Post("/login", handlers.LoginHandler(db, apiCfg))
 
 	// create access token with refresh token for /api namespaces
@@ -84,12 +165,24 @@ func main() {
 	// create a new router for the admin
 	adminRouter := chi.NewRouter()
 	adminRouter.Get("/metrics", handlers.MetricsHandler(apiCfg))
+	adminRouter.Get("/backup", handlers.BackupHandler(db, apiCfg))
 
 	// Mount the apiRouter at /api in the main router
 	r := chi.NewRouter()
 	r.Mount("/api", apiRouter)
 	r.Mount("/admin", adminRouter)
 
+	// Never serve the database files if the data directory sits inside the static root
+	if rel, err := filepath.Rel(filepathRoot, filepath.Dir(dbPath)); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+		if rel == "." {
+			for _, suffix := range []string{"", ".wal", ".wal.old", ".keys", "-wal", "-shm"} {
+				r.Handle("/"+filepath.Base(dbPath)+suffix, http.NotFoundHandler())
+			}
+		} else {
+			r.Handle("/"+filepath.ToSlash(rel)+"/*", http.NotFoundHandler())
+		}
+	}
+
 	// Serve static files from the root directory and add the middleware to track metrics
 	r.Mount("/", middleware.MiddlewareMetricsInc(http.FileServer(http.Dir(filepathRoot)), apiCfg))
 
@@ -102,17 +195,20 @@ func main() {
 		Handler: corsMux,
 	}
 
-	// Use the server's ListenAndServe method to start the server
-	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
-	// log.Fatal(srv.ListenAndServe())
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Println("ListenAndServe:", err)
-	}
-
 	// Set up an operating system signal handler to capture the Ctrl+C signal
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
+	// Use the server's ListenAndServe method to start the server
+	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
+	// log.Fatal(srv.ListenAndServe())
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println("ListenAndServe:", err)
+			signalChan <- syscall.SIGTERM
+		}
+	}()
+
 	// Wait for the signal
 	<-signalChan
 
@@ -121,3 +217,206 @@ func main() {
 		fmt.Println(err)
 	}
 }
+
+// databaseConfig returns the storage driver, database path and store options.
+// DB_DRIVER selects the backend ("json" or "sqlite"), DATA_DIR where the
+// database lives (default "data"), ID_GENERATOR how ids are generated
+// ("sequence" or "snowflake"), NODE_ID the snowflake node and
+// DB_ENCRYPTION_KEY (or DB_ENCRYPTION_KEY_FILE) the master key that encrypts
+// the database at rest.
+func databaseConfig() (driver, path string, opts database.Options) {
+	dataDir := dataDir()
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		log.Fatalf("creating data directory: %v", err)
+	}
+
+	driver = os.Getenv("DB_DRIVER")
+	path = filepath.Join(dataDir, "database.json")
+	if driver == "sqlite" {
+		path = filepath.Join(dataDir, "database.db")
+	}
+
+	opts.IDs = os.Getenv("ID_GENERATOR")
+	if nodeID := os.Getenv("NODE_ID"); nodeID != "" {
+		n, err := strconv.Atoi(nodeID)
+		if err != nil {
+			log.Fatalf("invalid NODE_ID %q", nodeID)
+		}
+		opts.NodeID = n
+	}
+	opts.MasterKey = masterKey("DB_ENCRYPTION_KEY")
+	return driver, path, opts
+}
+
+// dataDir returns the directory data is kept in, DATA_DIR or "data" by default
+func dataDir() string {
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		return dir
+	}
+	return "data"
+}
+
+// mediaDir returns where uploaded images are kept, MEDIA_DIR or "media" in
+// the data directory by default
+func mediaDir() string {
+	if dir := os.Getenv("MEDIA_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(dataDir(), "media")
+}
+
+// editConfig returns how long chirps can be edited after posting and whether
+// only Chirpy Red members can edit them. CHIRP_EDIT_WINDOW is a duration such
+// as "15m" (the default), or "0" for no limit; CHIRP_EDIT_RED_ONLY=true
+// limits editing to Chirpy Red members.
+func editConfig() (window time.Duration, redOnly bool) {
+	window = 15 * time.Minute
+	if s := os.Getenv("CHIRP_EDIT_WINDOW"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid CHIRP_EDIT_WINDOW %q", s)
+		}
+		window = d
+	}
+	if s := os.Getenv("CHIRP_EDIT_RED_ONLY"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("invalid CHIRP_EDIT_RED_ONLY %q", s)
+		}
+		redOnly = b
+	}
+	return window, redOnly
+}
+
+// timelineConfig returns how timelines are cached. With
+// TIMELINE_FANOUT_MAX_FOLLOWERS set, the newest TIMELINE_CACHE_SIZE (default
+// 200) chirps of each timeline are kept in memory, and chirps by authors with
+// up to that many followers are pushed to them as they are posted. At most
+// TIMELINE_CACHE_TIMELINES (default 10000) timelines are kept, dropping the
+// least recently read. Otherwise timelines are read from the database each
+// time.
+func timelineConfig() (maxFollowers, size, timelines int) {
+	size, timelines = 200, 10000
+	for name, n := range map[string]*int{"TIMELINE_FANOUT_MAX_FOLLOWERS": &maxFollowers, "TIMELINE_CACHE_SIZE": &size, "TIMELINE_CACHE_TIMELINES": &timelines} {
+		if s := os.Getenv(name); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil || v < 0 {
+				log.Fatalf("invalid %s %q", name, s)
+			}
+			*n = v
+		}
+	}
+	if size == 0 {
+		log.Fatalf("invalid TIMELINE_CACHE_SIZE 0")
+	}
+	if timelines == 0 {
+		log.Fatalf("invalid TIMELINE_CACHE_TIMELINES 0")
+	}
+	return maxFollowers, size, timelines
+}
+
+// validationConfig returns the rules chirps are checked against.
+// CHIRP_MAX_LENGTH and CHIRP_MAX_LENGTH_RED set the most characters in a
+// chirp, for everyone and for Chirpy Red members (140 and 280 by default);
+// PROFANITY_FILE names a file of words to mask, one per line, in place of the
+// built-in list.
+func validationConfig() validation.Config {
+	cfg := validation.DefaultConfig()
+	for name, limit := range map[string]*int{"CHIRP_MAX_LENGTH": &cfg.MaxLength, "CHIRP_MAX_LENGTH_RED": &cfg.MaxLengthRed} {
+		if s := os.Getenv(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				log.Fatalf("invalid %s %q", name, s)
+			}
+			*limit = n
+		}
+	}
+	if path := os.Getenv("PROFANITY_FILE"); path != "" {
+		words, err := validation.LoadWordList(path)
+		if err != nil {
+			log.Fatalf("loading PROFANITY_FILE: %v", err)
+		}
+		cfg.ProfaneWords = words
+	}
+	return cfg
+}
+
+// mailConfig sets up the emails users verify their address and reset their
+// password with. Emails are sent
+// through the SMTP server at SMTP_ADDR (host:port) if it is set, logging in
+// with SMTP_USERNAME and SMTP_PASSWORD, and otherwise saved to MAIL_DIR ("mail"
+// in the data directory by default), or logged if MAIL_DIR is "-". They come
+// from MAIL_FROM and link to VERIFY_EMAIL_URL and RESET_PASSWORD_URL if they
+// are set. VERIFICATION_RESEND_INTERVAL and PASSWORD_RESET_INTERVAL are how
+// long users wait between emails of each kind ("1m" by default), and
+// REQUIRE_VERIFIED_EMAIL=true stops unverified users from posting.
+func mailConfig(apiCfg *config.ApiConfig) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <noreply@chirpy.local>"
+	}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		apiCfg.Mailer = mail.NewSMTPMailer(addr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+	} else {
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = filepath.Join(dataDir(), "mail")
+		} else if dir == "-" {
+			dir = ""
+		}
+		mailer, err := mail.NewFileMailer(dir, from)
+		if err != nil {
+			log.Fatalf("opening MAIL_DIR: %v", err)
+		}
+		apiCfg.Mailer = mailer
+	}
+	apiCfg.VerifyEmailURL = os.Getenv("VERIFY_EMAIL_URL")
+
+	apiCfg.VerificationResendInterval = time.Minute
+	if s := os.Getenv("VERIFICATION_RESEND_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid VERIFICATION_RESEND_INTERVAL %q", s)
+		}
+		apiCfg.VerificationResendInterval = d
+	}
+	if s := os.Getenv("REQUIRE_VERIFIED_EMAIL"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("invalid REQUIRE_VERIFIED_EMAIL %q", s)
+		}
+		apiCfg.RequireVerifiedEmail = b
+	}
+
+	apiCfg.ResetPasswordURL = os.Getenv("RESET_PASSWORD_URL")
+	apiCfg.PasswordResetInterval = time.Minute
+	if s := os.Getenv("PASSWORD_RESET_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid PASSWORD_RESET_INTERVAL %q", s)
+		}
+		apiCfg.PasswordResetInterval = d
+	}
+}
+
+// masterKey reads a base64 master key from the env variable name, or from the
+// file named by name+"_FILE". It returns nil if neither is set.
+func masterKey(name string) []byte {
+	encoded := os.Getenv(name)
+	if file := os.Getenv(name + "_FILE"); encoded == "" && file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("reading %s: %v", name+"_FILE", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil
+	}
+
+	key, err := database.ParseMasterKey(encoded)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+	return key
+}